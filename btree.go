@@ -1,7 +1,10 @@
 package laurel
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math"
+	"slices"
 	"unsafe"
 )
 
@@ -10,6 +13,8 @@ type NodeType uint8
 const (
 	NODE_INTERNAL NodeType = iota
 	NODE_LEAF
+	NODE_FREELIST_TRUNK
+	NODE_OVERFLOW
 )
 
 const (
@@ -37,47 +42,58 @@ const (
 	INTERNAL_NODE_KEY_SIZE   = 4
 	INTERNAL_NODE_CHILD_SIZE = 4
 	INTERNAL_NODE_CELL_SIZE  = INTERNAL_NODE_CHILD_SIZE + INTERNAL_NODE_KEY_SIZE
-
-	/* Keep this small for testing */
-	INTERNAL_NODE_MAX_CELLS = 3
 	/*
 	 * Leaf Node Header Layout
 	 */
-	LEAF_NODE_NUM_CELLS_SIZE   = 4
-	LEAF_NODE_NUM_CELLS_OFFSET = COMMON_NODE_HEADER_SIZE
-	LEAF_NODE_NEXT_LEAF_SIZE   = 4
-	LEAF_NODE_NEXT_LEAF_OFFSET = LEAF_NODE_NUM_CELLS_OFFSET + LEAF_NODE_NUM_CELLS_SIZE
-	LEAF_NODE_HEADER_SIZE      = COMMON_NODE_HEADER_SIZE + LEAF_NODE_NUM_CELLS_SIZE + LEAF_NODE_NEXT_LEAF_SIZE
+	LEAF_NODE_NUM_CELLS_SIZE          = 4
+	LEAF_NODE_NUM_CELLS_OFFSET        = COMMON_NODE_HEADER_SIZE
+	LEAF_NODE_NEXT_LEAF_SIZE          = 4
+	LEAF_NODE_NEXT_LEAF_OFFSET        = LEAF_NODE_NUM_CELLS_OFFSET + LEAF_NODE_NUM_CELLS_SIZE
+	LEAF_NODE_CONTENT_START_SIZE      = 4
+	LEAF_NODE_CONTENT_START_OFFSET    = LEAF_NODE_NEXT_LEAF_OFFSET + LEAF_NODE_NEXT_LEAF_SIZE
+	LEAF_NODE_FRAGMENTED_BYTES_SIZE   = 4
+	LEAF_NODE_FRAGMENTED_BYTES_OFFSET = LEAF_NODE_CONTENT_START_OFFSET + LEAF_NODE_CONTENT_START_SIZE
+	LEAF_NODE_HEADER_SIZE             = COMMON_NODE_HEADER_SIZE + LEAF_NODE_NUM_CELLS_SIZE + LEAF_NODE_NEXT_LEAF_SIZE +
+		LEAF_NODE_CONTENT_START_SIZE + LEAF_NODE_FRAGMENTED_BYTES_SIZE
 
 	/*
 	 * Leaf Node Body Layout
+	 *
+	 * The header is followed by the cell pointers, the offset of each cell in
+	 * key order. Cells are packed at the end of the page, the content area
+	 * growing down towards the pointers. A cell is a key, the varint length of
+	 * a record, the varint length of the part of it stored in the cell and
+	 * that part, followed by the first overflow page of the rest if the record
+	 * spilled. Space freed in the middle of the content area is counted as
+	 * fragmented until the leaf is defragmented.
 	 */
-	LEAF_NODE_KEY_SIZE          = 4
-	LEAF_NODE_KEY_OFFSET        = 0
-	LEAF_NODE_VALUE_SIZE        = ROW_SIZE
-	LEAF_NODE_VALUE_OFFSET      = LEAF_NODE_KEY_OFFSET + LEAF_NODE_KEY_SIZE
-	LEAF_NODE_CELL_SIZE         = LEAF_NODE_KEY_SIZE + LEAF_NODE_VALUE_SIZE
-	LEAF_NODE_SPACE_FOR_CELLS   = PAGE_SIZE - LEAF_NODE_HEADER_SIZE
-	LEAF_NODE_MAX_CELLS         = LEAF_NODE_SPACE_FOR_CELLS / LEAF_NODE_CELL_SIZE
-	LEAF_NODE_RIGHT_SPLIT_COUNT = (LEAF_NODE_MAX_CELLS + 1) / 2
-	LEAF_NODE_LEFT_SPLIT_COUNT  = (LEAF_NODE_MAX_CELLS + 1) - LEAF_NODE_RIGHT_SPLIT_COUNT
+	LEAF_NODE_CELL_POINTER_SIZE    = 2
+	LEAF_NODE_KEY_SIZE             = 4
+	LEAF_NODE_KEY_OFFSET           = 0
+	LEAF_NODE_RECORD_LENGTH_OFFSET = LEAF_NODE_KEY_OFFSET + LEAF_NODE_KEY_SIZE
+	LEAF_NODE_OVERFLOW_PAGE_SIZE   = 4
+	// LEAF_NODE_MIN_CELL_SIZE is the size of a cell holding an empty record
+	LEAF_NODE_MIN_CELL_SIZE = LEAF_NODE_RECORD_LENGTH_OFFSET + 3
+	// LEAF_NODE_MAX_CELL_OVERHEAD is what a cell adds to the part of its
+	// record it stores, at most.
+	LEAF_NODE_MAX_CELL_OVERHEAD = LEAF_NODE_KEY_SIZE + 2*binary.MaxVarintLen64 + LEAF_NODE_OVERFLOW_PAGE_SIZE
 )
 
-func getNodeType(node *[PAGE_SIZE]byte) NodeType {
+func getNodeType(node []byte) NodeType {
 	value := node[NODE_TYPE_OFFSET]
 	return NodeType(value)
 }
 
-func setNodeType(node *[PAGE_SIZE]byte, typ NodeType) {
+func setNodeType(node []byte, typ NodeType) {
 	node[NODE_TYPE_OFFSET] = uint8(typ)
 }
 
-func isNodeRoot(node *[PAGE_SIZE]byte) bool {
+func isNodeRoot(node []byte) bool {
 	value := node[IS_ROOT_OFFSET]
 	return value != 0
 }
 
-func setNodeRoot(node *[PAGE_SIZE]byte, isRoot bool) {
+func setNodeRoot(node []byte, isRoot bool) {
 	if isRoot {
 		node[IS_ROOT_OFFSET] = 1
 	} else {
@@ -85,20 +101,20 @@ func setNodeRoot(node *[PAGE_SIZE]byte, isRoot bool) {
 	}
 }
 
-func internalNodeNumKeys(node *[PAGE_SIZE]byte) *uint32 {
+func internalNodeNumKeys(node []byte) *uint32 {
 	return (*uint32)(unsafe.Pointer(&node[INTERNAL_NODE_NUM_KEYS_OFFSET]))
 }
 
-func internalNodeRightChild(node *[PAGE_SIZE]byte) *uint32 {
+func internalNodeRightChild(node []byte) *uint32 {
 	return (*uint32)(unsafe.Pointer(&node[INTERNAL_NODE_RIGHT_CHILD_OFFSET]))
 }
 
-func internalNodeCell(node *[PAGE_SIZE]byte, cellNum uint32) *uint32 {
+func internalNodeCell(node []byte, cellNum uint32) *uint32 {
 	offset := INTERNAL_NODE_HEADER_SIZE + (cellNum * INTERNAL_NODE_CELL_SIZE)
 	return (*uint32)(unsafe.Pointer(&node[offset]))
 }
 
-func internalNodeChild(node *[PAGE_SIZE]byte, childNum uint32) *uint32 {
+func internalNodeChild(node []byte, childNum uint32) *uint32 {
 	numKeys := *internalNodeNumKeys(node)
 	if childNum > numKeys {
 		// TODO
@@ -109,52 +125,238 @@ func internalNodeChild(node *[PAGE_SIZE]byte, childNum uint32) *uint32 {
 	return internalNodeCell(node, childNum)
 }
 
-func internalNodeKey(node *[PAGE_SIZE]byte, keyNum uint32) *uint32 {
+func internalNodeKey(node []byte, keyNum uint32) *uint32 {
 	cellPtr := internalNodeCell(node, keyNum)
 	return (*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(cellPtr)) + uintptr(INTERNAL_NODE_CHILD_SIZE)))
 }
 
-func leafNodeNumCells(node *[PAGE_SIZE]byte) *uint32 {
+func leafNodeNumCells(node []byte) *uint32 {
 	return (*uint32)(unsafe.Pointer(&node[LEAF_NODE_NUM_CELLS_OFFSET]))
 }
 
-func leafNodeCell(node *[PAGE_SIZE]byte, cellNum uint32) *[LEAF_NODE_CELL_SIZE]byte {
-	offset := LEAF_NODE_HEADER_SIZE + (cellNum * LEAF_NODE_CELL_SIZE)
-	ptr := unsafe.Pointer(&node[offset])
-	return (*[LEAF_NODE_CELL_SIZE]byte)(ptr)
+func leafNodeContentStart(node []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&node[LEAF_NODE_CONTENT_START_OFFSET]))
+}
+
+func leafNodeFragmentedBytes(node []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&node[LEAF_NODE_FRAGMENTED_BYTES_OFFSET]))
+}
+
+func leafNodeCellPointer(node []byte, cellNum uint32) *uint16 {
+	offset := LEAF_NODE_HEADER_SIZE + cellNum*LEAF_NODE_CELL_POINTER_SIZE
+	return (*uint16)(unsafe.Pointer(&node[offset]))
+}
+
+// leafNodeCell returns the bytes of the cell at cellNum.
+func leafNodeCell(node []byte, cellNum uint32) []byte {
+	offset := uint32(*leafNodeCellPointer(node, cellNum))
+	return node[offset : offset+cellSize(node[offset:leafNodeUsableSize(node)])]
+}
+
+// cellSize returns the size of the cell at the start of data, or all of data
+// if it does not hold a well formed cell.
+func cellSize(data []byte) uint32 {
+	_, _, _, size := parseLeafNodeCell(data)
+	if size == 0 {
+		return uint32(len(data))
+	}
+	return size
+}
+
+// parseLeafNodeCell reads the cell at the start of data, returning the
+// length of its record, the part of the record stored in the cell, the
+// first overflow page of the rest or 0 if the record did not spill, and the
+// size of the cell. The size is 0 if data does not start with a well formed
+// cell.
+func parseLeafNodeCell(data []byte) (recordLength uint64, local []byte, overflowPageNum uint32, size uint32) {
+	if len(data) < LEAF_NODE_MIN_CELL_SIZE {
+		return 0, nil, 0, 0
+	}
+	offset := uint64(LEAF_NODE_RECORD_LENGTH_OFFSET)
+	recordLength, n := binary.Uvarint(data[offset:])
+	if n <= 0 {
+		return 0, nil, 0, 0
+	}
+	offset += uint64(n)
+	localLength, n := binary.Uvarint(data[offset:])
+	if n <= 0 || localLength > recordLength || offset+uint64(n)+localLength > uint64(len(data)) {
+		return 0, nil, 0, 0
+	}
+	offset += uint64(n)
+	local = data[offset : offset+localLength]
+	offset += localLength
+	if localLength < recordLength {
+		if offset+LEAF_NODE_OVERFLOW_PAGE_SIZE > uint64(len(data)) {
+			return 0, nil, 0, 0
+		}
+		overflowPageNum = binary.NativeEndian.Uint32(data[offset:])
+		offset += LEAF_NODE_OVERFLOW_PAGE_SIZE
+	}
+	return recordLength, local, overflowPageNum, uint32(offset)
+}
+
+func leafNodeKey(node []byte, cellNum uint32) *uint32 {
+	offset := *leafNodeCellPointer(node, cellNum)
+	return (*uint32)(unsafe.Pointer(&node[offset+LEAF_NODE_KEY_OFFSET]))
+}
+
+// leafNodeUsableSize is where the content area of a leaf ends, before the
+// checksum of its page.
+func leafNodeUsableSize(node []byte) uint32 {
+	return uint32(len(node)) - PAGE_CHECKSUM_SIZE
+}
+
+// leafNodeGap is the space between the cell pointers and the content area.
+func leafNodeGap(node []byte) uint32 {
+	return *leafNodeContentStart(node) - LEAF_NODE_HEADER_SIZE - *leafNodeNumCells(node)*LEAF_NODE_CELL_POINTER_SIZE
+}
+
+// leafNodeFreeSpace is the space left for cells and their pointers once the
+// leaf is defragmented.
+func leafNodeFreeSpace(node []byte) uint32 {
+	return leafNodeGap(node) + *leafNodeFragmentedBytes(node)
+}
+
+// cellsSize is the space cells take in a leaf, their pointers included.
+func cellsSize(cells [][]byte) uint32 {
+	size := uint32(0)
+	for _, cell := range cells {
+		size += uint32(len(cell)) + LEAF_NODE_CELL_POINTER_SIZE
+	}
+	return size
+}
+
+// leafNodeInsertCell inserts cell at cellNum, defragmenting the leaf if the
+// gap is too small. The caller makes sure the cell fits.
+func leafNodeInsertCell(node []byte, cellNum uint32, cell []byte) {
+	if leafNodeGap(node) < uint32(len(cell))+LEAF_NODE_CELL_POINTER_SIZE {
+		leafNodeDefragment(node)
+	}
+	numCells := *leafNodeNumCells(node)
+	offset := *leafNodeContentStart(node) - uint32(len(cell))
+	copy(node[offset:], cell)
+	*leafNodeContentStart(node) = offset
+
+	pointers := node[LEAF_NODE_HEADER_SIZE : LEAF_NODE_HEADER_SIZE+(numCells+1)*LEAF_NODE_CELL_POINTER_SIZE]
+	copy(pointers[(cellNum+1)*LEAF_NODE_CELL_POINTER_SIZE:], pointers[cellNum*LEAF_NODE_CELL_POINTER_SIZE:])
+	*leafNodeCellPointer(node, cellNum) = uint16(offset)
+	*leafNodeNumCells(node) = numCells + 1
+}
+
+// leafNodeRemoveCell removes the cell at cellNum. Its space joins the gap if
+// it starts the content area, and is fragmented otherwise.
+func leafNodeRemoveCell(node []byte, cellNum uint32) {
+	numCells := *leafNodeNumCells(node)
+	offset := uint32(*leafNodeCellPointer(node, cellNum))
+	size := uint32(len(leafNodeCell(node, cellNum)))
+	clear(node[offset : offset+size])
+	if offset == *leafNodeContentStart(node) {
+		*leafNodeContentStart(node) += size
+	} else {
+		*leafNodeFragmentedBytes(node) += size
+	}
+
+	pointers := node[LEAF_NODE_HEADER_SIZE : LEAF_NODE_HEADER_SIZE+numCells*LEAF_NODE_CELL_POINTER_SIZE]
+	copy(pointers[cellNum*LEAF_NODE_CELL_POINTER_SIZE:], pointers[(cellNum+1)*LEAF_NODE_CELL_POINTER_SIZE:])
+	clear(pointers[(numCells-1)*LEAF_NODE_CELL_POINTER_SIZE:])
+	*leafNodeNumCells(node) = numCells - 1
+}
+
+// leafNodeCells returns copies of the cells of a leaf in key order.
+func leafNodeCells(node []byte) [][]byte {
+	numCells := *leafNodeNumCells(node)
+	cells := make([][]byte, numCells)
+	for i := uint32(0); i < numCells; i++ {
+		cells[i] = slices.Clone(leafNodeCell(node, i))
+	}
+	return cells
+}
+
+// leafNodeSetCells replaces the cells of a leaf, packing them at the end of
+// the page.
+func leafNodeSetCells(node []byte, cells [][]byte) {
+	end := leafNodeUsableSize(node)
+	clear(node[LEAF_NODE_HEADER_SIZE:end])
+	*leafNodeNumCells(node) = 0
+	*leafNodeContentStart(node) = end
+	*leafNodeFragmentedBytes(node) = 0
+	for i, cell := range cells {
+		leafNodeInsertCell(node, uint32(i), cell)
+	}
+}
+
+// leafNodeDefragment moves the cells of a leaf together at the end of the
+// page, turning its fragmented space back into gap.
+func leafNodeDefragment(node []byte) {
+	leafNodeSetCells(node, leafNodeCells(node))
+}
+
+// leafNodeHasRoom reports whether cell can be inserted into the leaf.
+func (pager *Pager) leafNodeHasRoom(node []byte, cell []byte) bool {
+	return *leafNodeNumCells(node) < pager.leafNodeMaxCells &&
+		leafNodeFreeSpace(node) >= uint32(len(cell))+LEAF_NODE_CELL_POINTER_SIZE
+}
+
+// leafNodeFits reports whether cells fit together in one leaf.
+func (pager *Pager) leafNodeFits(cells [][]byte) bool {
+	return uint32(len(cells)) <= pager.leafNodeMaxCells && cellsSize(cells) <= pager.leafNodeSpaceForCells
 }
 
-func leafNodeKey(node *[PAGE_SIZE]byte, cellNum uint32) *uint32 {
-	cell := leafNodeCell(node, cellNum)
-	return (*uint32)(unsafe.Pointer(&cell[0]))
+// leafNodeUnderfull reports whether a non-root leaf holds so little that it
+// should borrow from or merge with a sibling.
+func (pager *Pager) leafNodeUnderfull(node []byte) bool {
+	used := pager.leafNodeSpaceForCells - leafNodeFreeSpace(node)
+	return used < pager.leafNodeMinFill && *leafNodeNumCells(node) < pager.leafNodeMinCells
 }
 
-func leafNodeValue(node *[PAGE_SIZE]byte, cellNum uint32) *[LEAF_NODE_CELL_SIZE - LEAF_NODE_KEY_SIZE]byte {
-	cell := leafNodeCell(node, cellNum)
-	return (*[LEAF_NODE_CELL_SIZE - LEAF_NODE_KEY_SIZE]byte)(unsafe.Pointer(&cell[LEAF_NODE_KEY_SIZE]))
+// leafNodeSplitPoint returns how many of cells to keep in the left of two
+// leaves so that both fit and are as evenly filled as can be, or 0 if the
+// cells cannot be divided that way.
+func (pager *Pager) leafNodeSplitPoint(cells [][]byte) int {
+	total := cellsSize(cells)
+	best, bestDiff := 0, uint32(math.MaxUint32)
+	left := uint32(0)
+	for i := 1; i < len(cells); i++ {
+		left += uint32(len(cells[i-1])) + LEAF_NODE_CELL_POINTER_SIZE
+		if !pager.leafNodeFits(cells[:i]) || !pager.leafNodeFits(cells[i:]) {
+			continue
+		}
+		diff := max(left, total-left) - min(left, total-left)
+		if diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+	return best
 }
 
-func leafNodeNextLeaf(node *[PAGE_SIZE]byte) *uint32 {
+func leafNodeNextLeaf(node []byte) *uint32 {
 	return (*uint32)(unsafe.Pointer(&node[LEAF_NODE_NEXT_LEAF_OFFSET]))
 }
 
-func getNodeMaxKey(node *[PAGE_SIZE]byte) uint32 {
+// getNodeMaxKey returns the largest key stored under node. For an internal
+// node that is the max key of its right-most subtree, so it needs the pager.
+func (pager *Pager) getNodeMaxKey(node []byte) uint32 {
 	switch getNodeType(node) {
 	case NODE_INTERNAL:
-		return *internalNodeKey(node, *internalNodeNumKeys(node)-1)
+		rightChild := pager.getPage(*internalNodeRightChild(node))
+		return pager.getNodeMaxKey(rightChild)
 	case NODE_LEAF:
-		return *leafNodeKey(node, *leafNodeNumCells(node)-1)
+		numCells := *leafNodeNumCells(node)
+		if numCells == 0 {
+			return 0
+		}
+		return *leafNodeKey(node, numCells-1)
 	default:
 		// handle unknown node type
 	}
 	return 0
 }
 
-func nodeParent(node *[PAGE_SIZE]byte) *uint32 {
+func nodeParent(node []byte) *uint32 {
 	return (*uint32)(unsafe.Pointer(&node[PARENT_POINTER_OFFSET]))
 }
 
-func internalNodeFindChild(node *[PAGE_SIZE]byte, key uint32) uint32 {
+func internalNodeFindChild(node []byte, key uint32) uint32 {
 	numKeys := *internalNodeNumKeys(node)
 
 	// 二分查找
@@ -174,30 +376,49 @@ func internalNodeFindChild(node *[PAGE_SIZE]byte, key uint32) uint32 {
 	return minIndex
 }
 
-func updateInternalNodeKey(node *[PAGE_SIZE]byte, oldKey, newKey uint32) {
+// internalNodeChildIndex returns the position of childPageNum among the
+// children of node, where numKeys stands for the right child.
+func internalNodeChildIndex(node []byte, childPageNum uint32) uint32 {
+	numKeys := *internalNodeNumKeys(node)
+	for i := uint32(0); i < numKeys; i++ {
+		if *internalNodeChild(node, i) == childPageNum {
+			return i
+		}
+	}
+	return numKeys
+}
+
+func updateInternalNodeKey(node []byte, oldKey, newKey uint32) {
 	oldChildIndex := internalNodeFindChild(node, oldKey)
+	if oldChildIndex == *internalNodeNumKeys(node) {
+		// The right child has no key of its own
+		return
+	}
 	*internalNodeKey(node, oldChildIndex) = newKey
 }
 
-func printConstants() string {
+func (pager *Pager) printConstants() string {
 	s := ""
-	s += fmt.Sprintf("ROW_SIZE: %d\n", ROW_SIZE)
 	s += fmt.Sprintf("COMMON_NODE_HEADER_SIZE: %d\n", COMMON_NODE_HEADER_SIZE)
 	s += fmt.Sprintf("LEAF_NODE_HEADER_SIZE: %d\n", LEAF_NODE_HEADER_SIZE)
-	s += fmt.Sprintf("LEAF_NODE_CELL_SIZE: %d\n", LEAF_NODE_CELL_SIZE)
-	s += fmt.Sprintf("LEAF_NODE_SPACE_FOR_CELLS: %d\n", LEAF_NODE_SPACE_FOR_CELLS)
-	s += fmt.Sprintf("LEAF_NODE_MAX_CELLS: %d\n", LEAF_NODE_MAX_CELLS)
+	s += fmt.Sprintf("LEAF_NODE_CELL_POINTER_SIZE: %d\n", LEAF_NODE_CELL_POINTER_SIZE)
+	s += fmt.Sprintf("LEAF_NODE_SPACE_FOR_CELLS: %d\n", pager.leafNodeSpaceForCells)
+	s += fmt.Sprintf("LEAF_NODE_MAX_CELLS: %d\n", pager.leafNodeMaxCells)
+	s += fmt.Sprintf("LEAF_NODE_MAX_LOCAL: %d\n", pager.leafNodeMaxLocal)
+	s += fmt.Sprintf("LEAF_NODE_MIN_LOCAL: %d\n", pager.leafNodeMinLocal)
 	return s
 }
 
-func initializeLeafNode(node *[PAGE_SIZE]byte) {
+func initializeLeafNode(node []byte) {
 	setNodeType(node, NODE_LEAF)
 	setNodeRoot(node, false)
 	*leafNodeNumCells(node) = 0
 	*leafNodeNextLeaf(node) = 0
+	*leafNodeContentStart(node) = leafNodeUsableSize(node)
+	*leafNodeFragmentedBytes(node) = 0
 }
 
-func initializeInternalNode(node *[PAGE_SIZE]byte) {
+func initializeInternalNode(node []byte) {
 	setNodeType(node, NODE_INTERNAL)
 	setNodeRoot(node, false)
 	*internalNodeNumKeys(node) = 0
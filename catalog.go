@@ -0,0 +1,150 @@
+package laurel
+
+import (
+	"math"
+	"slices"
+	"strings"
+)
+
+/*
+ * Catalog
+ *
+ * The catalog is a table rooted at page 1 with a row for every table of the
+ * database, giving its type, name, root page and the create statement that
+ * made it, from which its columns are parsed again. The catalog is what
+ * DBopen returns, statements find their tables through it.
+ */
+const (
+	CATALOG_ROOT_PAGE_NUM = 1
+	CATALOG_NAME          = "laurel_schema"
+	CATALOG_SCHEMA        = "create table laurel_schema (type text, name text, root integer, sql text)"
+	CATALOG_TYPE_COLUMN   = 0
+	CATALOG_NAME_COLUMN   = 1
+	CATALOG_ROOT_COLUMN   = 2
+	CATALOG_SQL_COLUMN    = 3
+	CATALOG_TYPE_TABLE    = "table"
+	CATALOG_TYPE_INDEX    = "index"
+
+	// DEFAULT_TABLE is created along with every database, statements that
+	// do not name a table use it.
+	DEFAULT_TABLE  = "users"
+	DEFAULT_SCHEMA = "create table users (id integer primary key, username text(32), email text(255))"
+)
+
+var (
+	catalogSchema = mustParseSchema(CATALOG_SCHEMA)
+	defaultSchema = mustParseSchema(DEFAULT_SCHEMA)
+)
+
+func mustParseSchema(sql string) *Schema {
+	schema, err := parseSchema(sql)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// catalogRow returns the row recording a table in the catalog.
+func catalogRow(ttype string, schema *Schema, rootPageNum uint32) []any {
+	return []any{ttype, schema.name, int64(rootPageNum), schema.sql}
+}
+
+// loadCatalog reads the tables from the catalog if the schema changed since
+// they were last read, as it does when a create table rolls back.
+func (catalog *Table) loadCatalog() error {
+	header := catalog.pager.getPage(DB_HEADER_PAGE_NUM)
+	schemaCookie := *headerSchemaCookie(header)
+	if catalog.tables != nil && schemaCookie == catalog.schemaCookie {
+		return nil
+	}
+
+	tables := make(map[string]*Table)
+	cursor := catalog.tableStart()
+	defer cursor.close()
+	for ; !cursor.endOfTable; cursor.cursorAdvance() {
+		row := cursor.cursorRow()
+		ttype, _ := row[CATALOG_TYPE_COLUMN].(string)
+		if ttype == CATALOG_TYPE_INDEX {
+			continue
+		}
+		name, _ := row[CATALOG_NAME_COLUMN].(string)
+		root, _ := row[CATALOG_ROOT_COLUMN].(int64)
+		sql, _ := row[CATALOG_SQL_COLUMN].(string)
+		if ttype != CATALOG_TYPE_TABLE || root <= CATALOG_ROOT_PAGE_NUM || root > math.MaxUint32 {
+			return ErrCorruptSchema
+		}
+		schema, err := parseSchema(sql)
+		if err != nil || !strings.EqualFold(schema.name, name) || tables[strings.ToLower(name)] != nil {
+			return ErrCorruptSchema
+		}
+		table := catalog.newTable(schema, uint32(root))
+		table.catalogKey = cursor.cursorKey()
+		tables[strings.ToLower(name)] = table
+	}
+
+	catalog.tables, catalog.schemaCookie = tables, schemaCookie
+	return nil
+}
+
+func (catalog *Table) newTable(schema *Schema, rootPageNum uint32) *Table {
+	return &Table{
+		pager:                catalog.pager,
+		rootPageNum:          rootPageNum,
+		internalNodeMaxCells: catalog.internalNodeMaxCells,
+		schema:               schema,
+	}
+}
+
+// findTable returns the named table, or nil if there is none.
+func (catalog *Table) findTable(name string) *Table {
+	if strings.EqualFold(name, CATALOG_NAME) {
+		return catalog
+	}
+	return catalog.tables[strings.ToLower(name)]
+}
+
+// tableNames returns the names of the tables in alphabetical order.
+func (catalog *Table) tableNames() []string {
+	names := make([]string, 0, len(catalog.tables))
+	for _, table := range catalog.tables {
+		names = append(names, table.schema.name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// createTable gives a new table an empty root and records it in the
+// catalog. The catalog reads it back once the statement is over.
+func (catalog *Table) createTable(schema *Schema) {
+	rootPageNum := catalog.pager.getUnusedPageNum()
+	root := catalog.pager.getWritablePage(rootPageNum)
+	initializeLeafNode(root)
+	setNodeRoot(root, true)
+
+	key, _ := catalog.nextKey()
+	cursor := catalog.tableFind(key)
+	cursor.leafNodeInsert(key, catalogSchema.encodeRow(catalogRow(CATALOG_TYPE_TABLE, schema, rootPageNum)))
+	cursor.close()
+
+	catalog.schemaChanged()
+}
+
+// dropTable frees every page of a table and removes it from the catalog.
+func (catalog *Table) dropTable(table *Table) {
+	catalog.pager.freeTree(table.rootPageNum)
+	catalog.tableDelete(table.catalogKey)
+	catalog.schemaChanged()
+}
+
+// alterTable replaces the schema of a table in the catalog. The rows of the
+// table are left as they are.
+func (catalog *Table) alterTable(table *Table, schema *Schema) {
+	catalog.tableReplace(table.catalogKey, catalogSchema.encodeRow(catalogRow(CATALOG_TYPE_TABLE, schema, table.rootPageNum)))
+	catalog.schemaChanged()
+}
+
+// schemaChanged bumps the schema cookie so that the tables are read again.
+func (catalog *Table) schemaChanged() {
+	header := catalog.pager.getWritablePage(DB_HEADER_PAGE_NUM)
+	*headerSchemaCookie(header)++
+}
@@ -0,0 +1,327 @@
+package laurel
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// integrityCheck collects the problems found while walking every page of
+// the database. Pages are copied out of the cache, since a check touches more
+// pages than the cache may hold.
+type integrityCheck struct {
+	table      *Table
+	problems   []string
+	referenced map[uint32]bool
+	leaves     []uint32 // in key order
+	leafDepth  uint32
+}
+
+// CheckIntegrity verifies the structure of the database file and returns a
+// description of every problem found, nil when there is none. It checks that
+// B-tree nodes are well formed and sorted, that separator keys and parent
+// pointers agree with the children, that the leaf chain visits every leaf in
+// key order, that overflow chains hold the rest of their records, and that
+// each page is used exactly once, by the catalog, a table, an overflow chain,
+// the freelist or the header.
+func (t *Table) CheckIntegrity() []string {
+	check := &integrityCheck{table: t, referenced: make(map[uint32]bool)}
+	check.referenced[DB_HEADER_PAGE_NUM] = true
+	header, ok := check.page(DB_HEADER_PAGE_NUM)
+	if !ok {
+		return check.problems
+	}
+
+	check.checkTree(CATALOG_NAME, t.rootPageNum)
+	for _, name := range t.tableNames() {
+		check.checkTree(name, t.findTable(name).rootPageNum)
+	}
+	check.checkFreelist(header)
+
+	if pageCount := *headerPageCount(header); pageCount != t.pager.numPages {
+		check.problemf("header: page count is %d, want %d", pageCount, t.pager.numPages)
+	}
+	for pageNum := uint32(0); pageNum < t.pager.numPages; pageNum++ {
+		if !check.referenced[pageNum] {
+			check.problemf("page %d: never used", pageNum)
+		}
+	}
+	return check.problems
+}
+
+func (check *integrityCheck) problemf(format string, args ...any) {
+	check.problems = append(check.problems, fmt.Sprintf(format, args...))
+}
+
+// page returns a copy of a page, or false if it cannot be read.
+func (check *integrityCheck) page(pageNum uint32) ([]byte, bool) {
+	var page []byte
+	err := catchCorruptPage(func() { page = slices.Clone(check.table.pager.getPage(pageNum)) })
+	if err != nil {
+		check.problemf("%v", err)
+		return nil, false
+	}
+	return page, true
+}
+
+func (check *integrityCheck) checkTree(name string, rootPageNum uint32) {
+	check.leaves, check.leafDepth = nil, 0
+	check.checkNode(rootPageNum, 0, true, 0, nil, nil, name)
+	check.checkLeafChain()
+}
+
+// reference marks a page as used by referrer, and returns false if it must
+// not be read because it is out of range or already used elsewhere.
+func (check *integrityCheck) reference(pageNum uint32, referrer string) bool {
+	if pageNum == 0 || pageNum >= check.table.pager.numPages {
+		check.problemf("%s: page %d is out of range", referrer, pageNum)
+		return false
+	}
+	if check.referenced[pageNum] {
+		check.problemf("%s: page %d is referenced more than once", referrer, pageNum)
+		return false
+	}
+	check.referenced[pageNum] = true
+	return true
+}
+
+// checkNode checks the subtree at pageNum, whose keys must be greater than
+// *low and at most *high, and returns its max key.
+func (check *integrityCheck) checkNode(pageNum, parentPageNum uint32, isRoot bool, depth uint32, low, high *uint32, tree string) uint32 {
+	referrer := "root of " + tree
+	if !isRoot {
+		referrer = fmt.Sprintf("page %d", parentPageNum)
+	}
+	if !check.reference(pageNum, referrer) {
+		return 0
+	}
+	node, ok := check.page(pageNum)
+	if !ok {
+		return 0
+	}
+
+	if isNodeRoot(node) != isRoot {
+		check.problemf("page %d: root flag is %v, want %v", pageNum, isNodeRoot(node), isRoot)
+	}
+	if !isRoot && *nodeParent(node) != parentPageNum {
+		check.problemf("page %d: parent pointer is %d, want %d", pageNum, *nodeParent(node), parentPageNum)
+	}
+
+	switch getNodeType(node) {
+	case NODE_LEAF:
+		return check.checkLeaf(pageNum, node, isRoot, depth, low, high)
+	case NODE_INTERNAL:
+		return check.checkInternal(pageNum, node, depth, low, high, tree)
+	default:
+		check.problemf("page %d: invalid node type %d", pageNum, getNodeType(node))
+		return 0
+	}
+}
+
+func (check *integrityCheck) checkKey(pageNum, cellNum, key uint32, prev, low, high *uint32) {
+	if prev != nil && key <= *prev {
+		check.problemf("page %d: key %d at cell %d is not greater than key %d before it", pageNum, key, cellNum, *prev)
+	}
+	if (low != nil && key <= *low) || (high != nil && key > *high) {
+		check.problemf("page %d: key %d at cell %d is outside the range of its parent", pageNum, key, cellNum)
+	}
+}
+
+func (check *integrityCheck) checkLeaf(pageNum uint32, node []byte, isRoot bool, depth uint32, low, high *uint32) uint32 {
+	check.leaves = append(check.leaves, pageNum)
+	if len(check.leaves) == 1 {
+		check.leafDepth = depth
+	} else if depth != check.leafDepth {
+		check.problemf("page %d: leaf at depth %d, want %d", pageNum, depth, check.leafDepth)
+	}
+
+	numCells := *leafNodeNumCells(node)
+	if numCells > check.table.pager.leafNodeMaxCells {
+		check.problemf("page %d: %d cells exceed the maximum of %d", pageNum, numCells, check.table.pager.leafNodeMaxCells)
+		return 0
+	}
+	if !check.checkLeafSpace(pageNum, node) {
+		return 0
+	}
+	if numCells == 0 {
+		if !isRoot {
+			check.problemf("page %d: empty leaf", pageNum)
+		}
+		return 0
+	}
+
+	var prev *uint32
+	for i := uint32(0); i < numCells; i++ {
+		key := *leafNodeKey(node, i)
+		check.checkKey(pageNum, i, key, prev, low, high)
+		check.checkOverflow(pageNum, i, leafNodeCell(node, i))
+		prev = &key
+	}
+	return *prev
+}
+
+// checkOverflow checks that a cell keeps as much of its record as it should,
+// and that the overflow pages it points at hold exactly the rest.
+func (check *integrityCheck) checkOverflow(pageNum, cellNum uint32, cell []byte) {
+	pager := check.table.pager
+	recordLength, local, overflowPageNum, _ := parseLeafNodeCell(cell)
+	if want := pager.leafNodeLocalSize(recordLength); uint32(len(local)) != want {
+		check.problemf("page %d: cell %d keeps %d bytes of its record, want %d", pageNum, cellNum, len(local), want)
+		return
+	}
+
+	rest := recordLength - uint64(len(local))
+	referrer := fmt.Sprintf("page %d cell %d", pageNum, cellNum)
+	for overflowPageNum != 0 && rest > 0 {
+		if !check.reference(overflowPageNum, referrer) {
+			return
+		}
+		page, ok := check.page(overflowPageNum)
+		if !ok {
+			return
+		}
+		if getNodeType(page) != NODE_OVERFLOW {
+			check.problemf("page %d: invalid node type %d, want an overflow page", overflowPageNum, getNodeType(page))
+			return
+		}
+		rest -= min(rest, uint64(pager.overflowPageCapacity))
+		referrer = fmt.Sprintf("overflow page %d", overflowPageNum)
+		overflowPageNum = *overflowNext(page)
+	}
+	if rest > 0 {
+		check.problemf("%s: overflow chain ends %d bytes early", referrer, rest)
+	} else if overflowPageNum != 0 {
+		check.problemf("%s: overflow chain continues past the end of the record", referrer)
+	}
+}
+
+// checkLeafSpace checks that the cells of a leaf lie in its content area
+// without overlapping, and that its fragmented bytes account for the rest of
+// the content area. It returns false if the cells cannot be read.
+func (check *integrityCheck) checkLeafSpace(pageNum uint32, node []byte) bool {
+	numCells := *leafNodeNumCells(node)
+	usableSize := leafNodeUsableSize(node)
+	contentStart := *leafNodeContentStart(node)
+	if pointersEnd := LEAF_NODE_HEADER_SIZE + numCells*LEAF_NODE_CELL_POINTER_SIZE; contentStart < pointersEnd || contentStart > usableSize {
+		check.problemf("page %d: content area starts at %d, want between %d and %d", pageNum, contentStart, pointersEnd, usableSize)
+		return false
+	}
+
+	type extent struct{ start, end uint32 }
+	cells := make([]extent, 0, numCells)
+	for i := uint32(0); i < numCells; i++ {
+		offset := uint32(*leafNodeCellPointer(node, i))
+		if offset < contentStart || offset+LEAF_NODE_MIN_CELL_SIZE > usableSize {
+			check.problemf("page %d: cell %d at offset %d is outside the content area", pageNum, i, offset)
+			return false
+		}
+		_, _, _, size := parseLeafNodeCell(node[offset:usableSize])
+		if size == 0 {
+			check.problemf("page %d: cell %d at offset %d runs past the end of the page", pageNum, i, offset)
+			return false
+		}
+		cells = append(cells, extent{offset, offset + size})
+	}
+
+	slices.SortFunc(cells, func(a, b extent) int { return cmp.Compare(a.start, b.start) })
+	used := uint32(0)
+	for i, cell := range cells {
+		if i > 0 && cell.start < cells[i-1].end {
+			check.problemf("page %d: cells overlap at offset %d", pageNum, cell.start)
+			return false
+		}
+		used += cell.end - cell.start
+	}
+	if fragmented := *leafNodeFragmentedBytes(node); used+fragmented != usableSize-contentStart {
+		check.problemf("page %d: fragmented bytes are %d, want %d", pageNum, fragmented, usableSize-contentStart-used)
+	}
+	return true
+}
+
+func (check *integrityCheck) checkInternal(pageNum uint32, node []byte, depth uint32, low, high *uint32, tree string) uint32 {
+	numKeys := *internalNodeNumKeys(node)
+	if numKeys > check.table.internalNodeMaxCells {
+		check.problemf("page %d: %d keys exceed the maximum of %d", pageNum, numKeys, check.table.internalNodeMaxCells)
+		return 0
+	}
+
+	var prev *uint32
+	childLow := low
+	for i := uint32(0); i <= numKeys; i++ {
+		childPageNum := *internalNodeChild(node, i)
+		childHigh := high
+		if i < numKeys {
+			key := *internalNodeKey(node, i)
+			check.checkKey(pageNum, i, key, prev, low, high)
+			prev, childHigh = &key, &key
+		}
+
+		maxKey := check.checkNode(childPageNum, pageNum, false, depth+1, childLow, childHigh, tree)
+		if i < numKeys && maxKey != *childHigh {
+			check.problemf("page %d: key %d at cell %d does not match max key %d of child %d",
+				pageNum, *childHigh, i, maxKey, childPageNum)
+		}
+		if i == numKeys {
+			return maxKey
+		}
+		childLow = childHigh
+	}
+	return 0
+}
+
+// checkLeafChain checks that leafNodeNextLeaf links the leaves of the tree
+// in key order, ending at 0, so that the chain visits each of them once.
+func (check *integrityCheck) checkLeafChain() {
+	if len(check.leaves) == 0 {
+		return
+	}
+	for i, pageNum := range check.leaves {
+		want := uint32(0)
+		if i+1 < len(check.leaves) {
+			want = check.leaves[i+1]
+		}
+		node, ok := check.page(pageNum)
+		if !ok {
+			continue
+		}
+		if next := *leafNodeNextLeaf(node); next != want {
+			check.problemf("page %d: next leaf is %d, want %d", pageNum, next, want)
+		}
+	}
+}
+
+func (check *integrityCheck) checkFreelist(header []byte) {
+	numFree := uint32(0)
+	referrer := "header"
+	for trunkPageNum := *headerFreelistTrunk(header); trunkPageNum != 0; {
+		if !check.reference(trunkPageNum, referrer) {
+			break
+		}
+		numFree++
+		trunk, ok := check.page(trunkPageNum)
+		if !ok {
+			break
+		}
+		if getNodeType(trunk) != NODE_FREELIST_TRUNK {
+			check.problemf("page %d: freelist trunk has node type %d", trunkPageNum, getNodeType(trunk))
+			break
+		}
+
+		referrer = fmt.Sprintf("freelist trunk %d", trunkPageNum)
+		numLeaves := *freelistTrunkNumLeaves(trunk)
+		if numLeaves > check.table.pager.freelistTrunkMaxLeaves {
+			check.problemf("page %d: %d free pages exceed the maximum of %d", trunkPageNum, numLeaves, check.table.pager.freelistTrunkMaxLeaves)
+			break
+		}
+		for i := uint32(0); i < numLeaves; i++ {
+			if check.reference(*freelistTrunkLeaf(trunk, i), referrer) {
+				numFree++
+			}
+		}
+		trunkPageNum = *freelistTrunkNext(trunk)
+	}
+
+	if freelistCount := *headerFreelistCount(header); freelistCount != numFree {
+		check.problemf("header: freelist count is %d, want %d", freelistCount, numFree)
+	}
+}
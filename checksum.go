@@ -0,0 +1,62 @@
+package laurel
+
+import (
+	"fmt"
+	"hash/crc32"
+	"unsafe"
+)
+
+/*
+ * Page Checksum Layout
+ *
+ * The last bytes of every page hold a CRC32C of the rest of it, set when the
+ * page is written and verified when it is read back, so that bit rot or a
+ * torn write is reported instead of being read as rows. Node layouts only
+ * use the usable size of the page before it.
+ */
+const PAGE_CHECKSUM_SIZE = 4
+
+// ErrCorruptPage reports a page whose checksum does not match its content.
+type ErrCorruptPage struct {
+	PageNum uint32
+}
+
+func (e *ErrCorruptPage) Error() string {
+	return fmt.Sprintf("page %d is corrupt", e.PageNum)
+}
+
+func pageChecksum(page []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&page[len(page)-PAGE_CHECKSUM_SIZE]))
+}
+
+func computePageChecksum(page []byte) uint32 {
+	return crc32.Checksum(page[:len(page)-PAGE_CHECKSUM_SIZE], castagnoliTable)
+}
+
+func setPageChecksum(page []byte) {
+	*pageChecksum(page) = computePageChecksum(page)
+}
+
+// verifyPageChecksum panics with an *ErrCorruptPage, which getPage callers
+// cannot handle one by one, for catchCorruptPage to turn into an error at the
+// statement boundary.
+func verifyPageChecksum(pageNum uint32, page []byte) {
+	if *pageChecksum(page) != computePageChecksum(page) {
+		panic(&ErrCorruptPage{PageNum: pageNum})
+	}
+}
+
+// catchCorruptPage runs f and returns the *ErrCorruptPage it ran into, if any.
+func catchCorruptPage(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			corrupt, ok := r.(*ErrCorruptPage)
+			if !ok {
+				panic(r)
+			}
+			err = corrupt
+		}
+	}()
+	f()
+	return nil
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/summer-boythink/laurel"
 )
 
-func runScript(t *testing.T, commands []string, isPrevDel bool) []string {
+func runScript(t *testing.T, commands []string, isPrevDel bool, opts ...laurel.Option) []string {
 	var output []string
 	var file *os.File
 
@@ -26,6 +31,8 @@ func runScript(t *testing.T, commands []string, isPrevDel bool) []string {
 			fmt.Println("Error deleting file:", err)
 			return nil
 		}
+		os.Remove("test.db-journal")
+		os.Remove("test.db-wal")
 		file, err = os.Create("test.db")
 		if err != nil {
 			fmt.Println("Error creating file:", err)
@@ -39,7 +46,7 @@ func runScript(t *testing.T, commands []string, isPrevDel bool) []string {
 		}
 	}
 
-	tempTable, err := laurel.DBopen(file.Name())
+	tempTable, err := laurel.DBopen(file.Name(), opts...)
 	if err != nil {
 		fmt.Println("laurel open error:", err)
 		return nil
@@ -217,7 +224,7 @@ func TestPrintingThreeLeafNodeBTreeStructure(t *testing.T) {
 	insertCommands = append(insertCommands, "insert 15 user15 person15@example.com")
 	insertCommands = append(insertCommands, ".exit")
 
-	result := runScript(t, insertCommands, true)
+	result := runScript(t, insertCommands, true, laurel.WithLeafNodeMaxCells(13))
 	expected := []string{
 		"Tree:\n",
 		"- internal (size 1)\n",
@@ -252,12 +259,13 @@ func TestPrintingConstants(t *testing.T) {
 	result := runScript(t, insertCommands, true)
 	expected := []string{
 		"Constants:\n",
-		"ROW_SIZE: 291\n",
 		"COMMON_NODE_HEADER_SIZE: 6\n",
-		"LEAF_NODE_HEADER_SIZE: 14\n",
-		"LEAF_NODE_CELL_SIZE: 295\n",
-		"LEAF_NODE_SPACE_FOR_CELLS: 4082\n",
-		"LEAF_NODE_MAX_CELLS: 13\n",
+		"LEAF_NODE_HEADER_SIZE: 22\n",
+		"LEAF_NODE_CELL_POINTER_SIZE: 2\n",
+		"LEAF_NODE_SPACE_FOR_CELLS: 4070\n",
+		"LEAF_NODE_MAX_CELLS: 452\n",
+		"LEAF_NODE_MAX_LOCAL: 2005\n",
+		"LEAF_NODE_MIN_LOCAL: 508\n",
 	}
 	if strings.Join(result, "") != strings.Join(expected, "") {
 		t.Errorf("TestPrintingConstants failed, got: %v, want: %v", result, expected)
@@ -299,7 +307,7 @@ func TestPrintBTreeStructure(t *testing.T) {
 		".btree",
 		".exit",
 	}
-	result := runScript(t, insertCommands, true)
+	result := runScript(t, insertCommands, true, laurel.WithLeafNodeMaxCells(13))
 	expected := []string{
 		"Tree:\n",
 		"- internal (size 3)\n",
@@ -345,3 +353,1968 @@ func TestPrintBTreeStructure(t *testing.T) {
 		t.Errorf("TestPrintBTreeStructure failed, got: %v, want: %v", result[30:], expected)
 	}
 }
+
+func TestPrintingInternalNodeSplitBTreeStructure(t *testing.T) {
+	insertCommands := make([]string, 0)
+	for i := 1; i <= 36; i++ {
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	insertCommands = append(insertCommands, ".btree", ".exit")
+
+	result := runScript(t, insertCommands, true, laurel.WithLeafNodeMaxCells(13), laurel.WithInternalNodeMaxCells(3))
+	expected := []string{
+		"Tree:\n",
+		"- internal (size 1)\n",
+		"  - internal (size 1)\n",
+		"    - leaf (size 7)\n",
+		"      - 1\n", "      - 2\n", "      - 3\n", "      - 4\n", "      - 5\n", "      - 6\n", "      - 7\n",
+		"    - key 7\n",
+		"    - leaf (size 7)\n",
+		"      - 8\n", "      - 9\n", "      - 10\n", "      - 11\n", "      - 12\n", "      - 13\n", "      - 14\n",
+		"  - key 14\n",
+		"  - internal (size 2)\n",
+		"    - leaf (size 7)\n",
+		"      - 15\n", "      - 16\n", "      - 17\n", "      - 18\n", "      - 19\n", "      - 20\n", "      - 21\n",
+		"    - key 21\n",
+		"    - leaf (size 7)\n",
+		"      - 22\n", "      - 23\n", "      - 24\n", "      - 25\n", "      - 26\n", "      - 27\n", "      - 28\n",
+		"    - key 28\n",
+		"    - leaf (size 8)\n",
+		"      - 29\n", "      - 30\n", "      - 31\n", "      - 32\n", "      - 33\n", "      - 34\n", "      - 35\n", "      - 36\n",
+	}
+	if strings.Join(result[36:], "") != strings.Join(expected, "") {
+		t.Errorf("TestPrintingInternalNodeSplitBTreeStructure failed, got: %v, want: %v", result[36:], expected)
+	}
+}
+
+func TestInsertManyRowsAcrossInternalNodeSplits(t *testing.T) {
+	const numRows = 300
+	insertCommands := make([]string, 0)
+	// 11 is coprime with 301, so this visits every id once in scrambled order
+	for i := 1; i <= numRows; i++ {
+		id := i * 11 % (numRows + 1)
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", id, id, id))
+	}
+	insertCommands = append(insertCommands, ".exit")
+	runScript(t, insertCommands, true, laurel.WithInternalNodeMaxCells(3))
+
+	result := runScript(t, []string{"select", ".exit"}, false, laurel.WithInternalNodeMaxCells(3))
+	expected := make([]string, 0)
+	for id := 1; id <= numRows; id++ {
+		expected = append(expected, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", id, id, id))
+	}
+	expected = append(expected, "Executed.\n")
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestInsertManyRowsAcrossInternalNodeSplits failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestDeleteRow(t *testing.T) {
+	result := runScript(t, []string{
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		"insert 3 user3 person3@example.com",
+		"delete where id = 2",
+		"delete where id = 4",
+		"select",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"Rows affected: 0\n",
+		"Executed.\n",
+		"(1, user1, person1@example.com)\n",
+		"(3, user3, person3@example.com)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestDeleteRow failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestDeleteSyntaxErrorMessage(t *testing.T) {
+	result := runScript(t, []string{
+		"delete where id = -1",
+		"delete where name = 1",
+		"delete where id between 1",
+		".exit",
+	}, true)
+	expected := []string{
+		"ID must be positive.\n",
+		"Syntax error at line 1, column 14: expected \"id\", got \"name\".\n",
+		"Syntax error at line 1, column 26: expected \"and\", got end of input.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestDeleteSyntaxErrorMessage failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestDeleteMergesNodesAndCollapsesRoot(t *testing.T) {
+	insertCommands := make([]string, 0)
+	for i := 1; i <= 36; i++ {
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	insertCommands = append(insertCommands,
+		"delete where id between 3 and 20", ".btree",
+		"delete where id > 30", ".btree",
+		".exit")
+
+	result := runScript(t, insertCommands, true, laurel.WithLeafNodeMaxCells(13), laurel.WithInternalNodeMaxCells(3))
+	expected := []string{
+		"Rows affected: 18\n",
+		"Executed.\n",
+		"Tree:\n",
+		"- internal (size 1)\n",
+		"  - leaf (size 10)\n",
+		"    - 1\n", "    - 2\n", "    - 21\n", "    - 22\n", "    - 23\n",
+		"    - 24\n", "    - 25\n", "    - 26\n", "    - 27\n", "    - 28\n",
+		"  - key 28\n",
+		"  - leaf (size 8)\n",
+		"    - 29\n", "    - 30\n", "    - 31\n", "    - 32\n", "    - 33\n", "    - 34\n", "    - 35\n", "    - 36\n",
+		"Rows affected: 6\n",
+		"Executed.\n",
+		"Tree:\n",
+		"- leaf (size 12)\n",
+		"  - 1\n", "  - 2\n", "  - 21\n", "  - 22\n", "  - 23\n", "  - 24\n",
+		"  - 25\n", "  - 26\n", "  - 27\n", "  - 28\n", "  - 29\n", "  - 30\n",
+	}
+	if strings.Join(result[36:], "") != strings.Join(expected, "") {
+		t.Errorf("TestDeleteMergesNodesAndCollapsesRoot failed, got: %v, want: %v", result[36:], expected)
+	}
+}
+
+func TestDeleteManyRows(t *testing.T) {
+	const numRows = 300
+	commands := make([]string, 0)
+	for i := 1; i <= numRows; i++ {
+		id := i * 11 % (numRows + 1)
+		commands = append(commands, fmt.Sprintf("insert %d user%d person%d@example.com", id, id, id))
+	}
+	for i := 1; i <= numRows; i++ {
+		if id := i * 13 % (numRows + 1); id%3 != 0 {
+			commands = append(commands, fmt.Sprintf("delete where id = %d", id))
+		}
+	}
+	commands = append(commands, ".exit")
+	runScript(t, commands, true, laurel.WithInternalNodeMaxCells(3))
+
+	result := runScript(t, []string{"select", ".exit"}, false, laurel.WithInternalNodeMaxCells(3))
+	expected := make([]string, 0)
+	for id := 3; id <= numRows; id += 3 {
+		expected = append(expected, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", id, id, id))
+	}
+	expected = append(expected, "Executed.\n")
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestDeleteManyRows (part 1) failed, got: %v, want: %v", result, expected)
+	}
+
+	result = runScript(t, []string{
+		"delete",
+		"select",
+		"insert 7 user7 person7@example.com",
+		"select",
+		".exit",
+	}, false, laurel.WithInternalNodeMaxCells(3))
+	expected = []string{
+		"Rows affected: 100\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"(7, user7, person7@example.com)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestDeleteManyRows (part 2) failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestDeleteLargeRows(t *testing.T) {
+	// Rows this large take a leaf of their own, which deleting them empties
+	body := strings.Repeat("x", 1500)
+	script := []string{"create table docs (id integer primary key, body text)"}
+	for i := 1; i <= 12; i++ {
+		script = append(script, fmt.Sprintf("insert into docs values (%d, '%s')", i, body))
+	}
+	script = append(script,
+		"delete from docs where id = 3",
+		"delete from docs where id = 12",
+		".check",
+		"select id from docs where id = 2",
+		"insert into docs values (2, 'dup')",
+		"insert into docs values (3, 'again')",
+		"insert into docs values (12, 'again')",
+		".check",
+		"select id from docs",
+		".exit",
+	)
+	result := runScript(t, script, true)
+	expected := []string{
+		"Rows affected: 1\n", "Executed.\n",
+		"Rows affected: 1\n", "Executed.\n",
+		"ok\n",
+		"(2)\n", "Executed.\n",
+		"Error: Duplicate key.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"ok\n",
+	}
+	for i := 1; i <= 12; i++ {
+		expected = append(expected, fmt.Sprintf("(%d)\n", i))
+	}
+	expected = append(expected, "Executed.\n")
+	// Past the create table and inserts
+	if len(result) < 13 || !reflect.DeepEqual(result[13:], expected) {
+		t.Errorf("TestDeleteLargeRows failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestUpdateRow(t *testing.T) {
+	result := runScript(t, []string{
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		"update set username = bob, email = bob@example.com where id = 2",
+		"update set email = alice@example.com where id = 1",
+		"update set username = carol where id = 3",
+		"select",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"Error: Row not found.\n",
+		"(1, user1, alice@example.com)\n",
+		"(2, bob, bob@example.com)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestUpdateRow failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestUpdateErrorMessages(t *testing.T) {
+	result := runScript(t, []string{
+		"insert 1 user1 person1@example.com",
+		fmt.Sprintf("update set username = %s where id = 1", strings.Repeat("a", 33)),
+		"update set id = 2 where id = 1",
+		"update set username = bob where id > 1",
+		"update set username = bob where id = -1",
+		"select",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"String is too long.\n",
+		"Syntax error at line 1, column 12: cannot update column \"id\".\n",
+		"Syntax error at line 1, column 36: update must select its row with where id = <id>.\n",
+		"ID must be positive.\n",
+		"(1, user1, person1@example.com)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestUpdateErrorMessages failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestFreelistReusesPages(t *testing.T) {
+	commands := make([]string, 0)
+	for i := 1; i <= 36; i++ {
+		commands = append(commands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	commands = append(commands, ".freelist", "delete where id between 3 and 20", ".freelist", ".exit")
+	result := runScript(t, commands, true, laurel.WithLeafNodeMaxCells(13), laurel.WithInternalNodeMaxCells(3))
+	expected := []string{
+		"Freelist (0 pages):\n",
+		"Rows affected: 18\n",
+		"Executed.\n",
+		"Freelist (5 pages):\n",
+		"- trunk 9 (size 4)\n",
+		"  - 8\n", "  - 3\n", "  - 5\n", "  - 6\n",
+	}
+	if strings.Join(result[36:], "") != strings.Join(expected, "") {
+		t.Errorf("TestFreelistReusesPages (part 1) failed, got: %v, want: %v", result[36:], expected)
+	}
+	fileInfo, err := os.Stat("test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizeAfterDelete := fileInfo.Size()
+
+	commands = make([]string, 0)
+	for i := 3; i <= 14; i++ {
+		commands = append(commands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	commands = append(commands, ".freelist", ".exit")
+	result = runScript(t, commands, false, laurel.WithLeafNodeMaxCells(13), laurel.WithInternalNodeMaxCells(3))
+	expected = []string{
+		"Freelist (3 pages):\n",
+		"- trunk 9 (size 2)\n",
+		"  - 8\n", "  - 3\n",
+	}
+	if strings.Join(result[12:], "") != strings.Join(expected, "") {
+		t.Errorf("TestFreelistReusesPages (part 2) failed, got: %v, want: %v", result[12:], expected)
+	}
+	fileInfo, err = os.Stat("test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileInfo.Size() != sizeAfterDelete {
+		t.Errorf("TestFreelistReusesPages failed, file grew from %d to %d bytes", sizeAfterDelete, fileInfo.Size())
+	}
+}
+
+func TestSmallPageCacheEvictsPages(t *testing.T) {
+	// Far more pages than the cache holds, and than the old 100 page limit
+	const numRows = 1500
+	opts := []laurel.Option{laurel.WithCacheSize(laurel.MIN_CACHE_SIZE), laurel.WithLeafNodeMaxCells(13), laurel.WithInternalNodeMaxCells(3)}
+	commands := make([]string, 0)
+	// 7 is coprime with 1501, so this visits every id once in scrambled order
+	for i := 1; i <= numRows; i++ {
+		id := i * 7 % (numRows + 1)
+		commands = append(commands, fmt.Sprintf("insert %d user%d person%d@example.com", id, id, id))
+	}
+	for i := 1; i <= numRows; i++ {
+		if id := i * 11 % (numRows + 1); id%2 == 1 {
+			commands = append(commands, fmt.Sprintf("delete where id = %d", id))
+		}
+	}
+	commands = append(commands, ".exit")
+	runScript(t, commands, true, opts...)
+
+	fileInfo, err := os.Stat("test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileInfo.Size() <= 100*4096 {
+		t.Errorf("TestSmallPageCacheEvictsPages failed, database only has %d bytes", fileInfo.Size())
+	}
+
+	result := runScript(t, []string{"select", ".exit"}, false, opts...)
+	expected := make([]string, 0)
+	for id := 2; id <= numRows; id += 2 {
+		expected = append(expected, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", id, id, id))
+	}
+	expected = append(expected, "Executed.\n")
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestSmallPageCacheEvictsPages failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestOnlyModifiedPagesAreWritten(t *testing.T) {
+	insertCommands := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	insertCommands = append(insertCommands, ".exit")
+	runScript(t, insertCommands, true, laurel.WithLeafNodeMaxCells(13))
+
+	table, err := laurel.DBopen("test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := make(chan string)
+	opt := laurel.Options{IsTestCmd: true, InputCmd: cmd, ResMsg: make(chan string)}
+	resMsg := laurel.Run(table, laurel.NewInputBuffer(), laurel.WithOptions(opt))
+
+	// Every page gets read, then the file changes behind the pager's back
+	cmd <- "select"
+	for msg := range resMsg {
+		if msg == "Executed.\n" {
+			break
+		}
+	}
+	file, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unused space between the cell pointers and cells of the right leaf (page 3) and left leaf (page 4)
+	marker := []byte("untouched")
+	for _, pageNum := range []int64{3, 4} {
+		if _, err := file.WriteAt(marker, pageNum*4096+2000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	// Only the right leaf is modified by this insert
+	cmd <- "insert 15 user15 person15@example.com"
+	<-resMsg
+	cmd <- ".exit"
+	for range resMsg {
+	}
+	close(cmd)
+
+	contents, err := os.ReadFile("test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(contents[3*4096+2000:][:len(marker)], marker) {
+		t.Errorf("TestOnlyModifiedPagesAreWritten failed, modified page 3 was not written")
+	}
+	if !bytes.Equal(contents[4*4096+2000:][:len(marker)], marker) {
+		t.Errorf("TestOnlyModifiedPagesAreWritten failed, unmodified page 4 was written")
+	}
+}
+
+func TestRollbackJournalRecoversFromCrash(t *testing.T) {
+	committed := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		committed = append(committed, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	crashing := []string{"begin"}
+	for i := 15; i <= 60; i++ {
+		crashing = append(crashing, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	crashing = append(crashing, "delete where id between 3 and 9", "commit", ".exit")
+	expected := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		expected = append(expected, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", i, i, i))
+	}
+	expected = append(expected, "Executed.\n")
+
+	for _, cacheSize := range []uint32{laurel.DEFAULT_CACHE_SIZE, laurel.MIN_CACHE_SIZE} {
+		for crashAtWrite := uint32(1); crashAtWrite <= 8; crashAtWrite++ {
+			opts := []laurel.Option{laurel.WithCacheSize(cacheSize), laurel.WithLeafNodeMaxCells(13), laurel.WithInternalNodeMaxCells(3)}
+			runScript(t, append(committed, ".exit"), true, opts...)
+			runScript(t, crashing, false, append(opts, laurel.WithCrashAtWrite(crashAtWrite))...)
+			if _, err := os.Stat("test.db-journal"); err != nil {
+				t.Fatalf("TestRollbackJournalRecoversFromCrash failed, no hot journal after crash at write %d: %v", crashAtWrite, err)
+			}
+
+			result := runScript(t, []string{"select", ".exit"}, false, opts...)
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("TestRollbackJournalRecoversFromCrash failed at write %d with cache size %d, got: %v, want: %v",
+					crashAtWrite, cacheSize, result, expected)
+			}
+			if _, err := os.Stat("test.db-journal"); !os.IsNotExist(err) {
+				t.Errorf("TestRollbackJournalRecoversFromCrash failed, journal left after recovery: %v", err)
+			}
+		}
+	}
+}
+
+func TestCommitRemovesJournal(t *testing.T) {
+	runScript(t, []string{"insert 1 user1 person1@example.com", ".exit"}, true)
+	if _, err := os.Stat("test.db-journal"); !os.IsNotExist(err) {
+		t.Errorf("TestCommitRemovesJournal failed, journal left after commit: %v", err)
+	}
+}
+
+func TestWalModeKeepsDataAfterClosingConnection(t *testing.T) {
+	wal := laurel.WithJournalMode(laurel.JOURNAL_MODE_WAL)
+	runScript(t, []string{
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		".exit",
+	}, true, wal)
+	if _, err := os.Stat("test.db-wal"); !os.IsNotExist(err) {
+		t.Errorf("TestWalModeKeepsDataAfterClosingConnection failed, WAL left after close: %v", err)
+	}
+
+	result := runScript(t, []string{"select", ".checkpoint", ".exit"}, false, wal)
+	expected := []string{
+		"(1, user1, person1@example.com)\n",
+		"(2, user2, person2@example.com)\n",
+		"Executed.\n",
+		"Checkpointed 0 pages.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestWalModeKeepsDataAfterClosingConnection failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestWalRecoversCommittedFrames(t *testing.T) {
+	wal := laurel.WithJournalMode(laurel.JOURNAL_MODE_WAL)
+	committed := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		committed = append(committed, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	crashing := []string{"begin"}
+	for i := 15; i <= 60; i++ {
+		crashing = append(crashing, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	crashing = append(crashing, "delete where id between 3 and 9", "commit", ".exit")
+
+	before, after := make([]string, 0), make([]string, 0)
+	for i := 1; i <= 60; i++ {
+		row := fmt.Sprintf("(%d, user%d, person%d@example.com)\n", i, i, i)
+		if i <= 14 {
+			before = append(before, row)
+		}
+		if i < 3 || i > 9 {
+			after = append(after, row)
+		}
+	}
+	before = append(before, "Executed.\n")
+	after = append(after, "Executed.\n")
+
+	sawBefore, sawRecovered := false, false
+	for crashAtWrite := uint32(1); crashAtWrite <= 40; crashAtWrite++ {
+		opts := []laurel.Option{wal, laurel.WithCacheSize(laurel.MIN_CACHE_SIZE), laurel.WithInternalNodeMaxCells(3)}
+		runScript(t, append(committed, ".exit"), true, opts...)
+		runScript(t, crashing, false, append(opts, laurel.WithCrashAtWrite(crashAtWrite))...)
+
+		// Readers find the committed pages in the WAL until it is checkpointed
+		result := runScript(t, []string{"select", ".checkpoint", ".exit"}, false, opts...)
+		if len(result) == 0 {
+			t.Fatalf("TestWalRecoversCommittedFrames failed at write %d, no output", crashAtWrite)
+		}
+		rows, checkpointed := result[:len(result)-1], result[len(result)-1]
+		switch {
+		case reflect.DeepEqual(rows, before) && checkpointed == "Checkpointed 0 pages.\n":
+			sawBefore = true
+		case reflect.DeepEqual(rows, after):
+			// Crashes past the checkpoint leave nothing in the WAL
+			sawRecovered = sawRecovered || checkpointed != "Checkpointed 0 pages.\n"
+		default:
+			t.Errorf("TestWalRecoversCommittedFrames failed at write %d, got: %v, want: %v or %v",
+				crashAtWrite, result, before, after)
+		}
+
+		reopened := runScript(t, []string{"select", ".exit"}, false)
+		if !reflect.DeepEqual(reopened, rows) {
+			t.Errorf("TestWalRecoversCommittedFrames failed at write %d after checkpoint, got: %v, want: %v",
+				crashAtWrite, reopened, rows)
+		}
+	}
+	if !sawBefore || !sawRecovered {
+		t.Errorf("TestWalRecoversCommittedFrames failed, crashes did not cover both sides of the commit frame")
+	}
+}
+
+func TestWalSpillsLargeTransactions(t *testing.T) {
+	opts := []laurel.Option{
+		laurel.WithJournalMode(laurel.JOURNAL_MODE_WAL),
+		laurel.WithCacheSize(laurel.MIN_CACHE_SIZE),
+		laurel.WithLeafNodeMaxCells(13),
+	}
+	runScript(t, []string{"insert 1 user1 person1@example.com", ".exit"}, true, opts...)
+
+	table, err := laurel.DBopen("test.db", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := make(chan string)
+	opt := laurel.Options{IsTestCmd: true, InputCmd: cmd, ResMsg: make(chan string)}
+	resMsg := laurel.Run(table, laurel.NewInputBuffer(), laurel.WithOptions(opt))
+
+	// Far more leaves than the cache holds, none of them committed
+	transaction := []string{"begin"}
+	for i := 2; i <= 400; i++ {
+		transaction = append(transaction, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	for _, command := range transaction {
+		cmd <- command
+		<-resMsg
+	}
+	fileInfo, err := os.Stat("test.db-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileInfo.Size() <= int64(laurel.WAL_HEADER_SIZE+laurel.MIN_CACHE_SIZE*4096) {
+		t.Errorf("TestWalSpillsLargeTransactions failed, WAL only has %d bytes", fileInfo.Size())
+	}
+
+	// The transaction reads its spilled pages back, a rollback discards them
+	rows := make([]string, 0)
+	for i := 1; i <= 400; i++ {
+		rows = append(rows, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", i, i, i))
+	}
+	result := make([]string, 0)
+	for _, command := range []string{"select", "rollback", "select"} {
+		cmd <- command
+		for msg := range resMsg {
+			result = append(result, msg)
+			if msg == "Executed.\n" {
+				break
+			}
+		}
+	}
+	expected := append(append(slices.Clone(rows), "Executed.\n", "Executed.\n"), rows[0], "Executed.\n")
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestWalSpillsLargeTransactions failed, got: %v, want: %v", result, expected)
+	}
+
+	// The next transaction writes over the frames of the rolled back one
+	for _, command := range append(transaction[:200], "commit") {
+		cmd <- command
+		<-resMsg
+	}
+	cmd <- ".exit"
+	for range resMsg {
+	}
+	close(cmd)
+
+	result = runScript(t, []string{"select", ".exit"}, false, opts...)
+	expected = append(slices.Clone(rows[:200]), "Executed.\n")
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestWalSpillsLargeTransactions failed after reopening, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestCheckpointWithinTransaction(t *testing.T) {
+	opts := []laurel.Option{laurel.WithJournalMode(laurel.JOURNAL_MODE_WAL), laurel.WithCacheSize(laurel.MIN_CACHE_SIZE)}
+	email := strings.Repeat("e", 200)
+	script := []string{"insert 1 user1 " + email, "begin"}
+	for i := 2; i <= 400; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d %s", i, i, email))
+	}
+	script = append(script, ".checkpoint", "commit", ".checkpoint", ".exit")
+	result := runScript(t, script, true, opts...)
+	expected := []string{"Error: Cannot checkpoint within a transaction.\n", "Executed.\n"}
+	if len(result) < 401 || !reflect.DeepEqual(result[401:403], expected) {
+		t.Errorf("TestCheckpointWithinTransaction failed, got: %v, want: %v", result, expected)
+	}
+
+	result = runScript(t, []string{".check", "select id from users where id > 398", ".exit"}, false, opts...)
+	expected = []string{"ok\n", "(399)\n", "(400)\n", "Executed.\n"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCheckpointWithinTransaction failed after reopening, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	runScript(t, []string{
+		"begin",
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		"commit",
+		".exit",
+	}, true)
+	result := runScript(t, []string{"select", ".exit"}, false)
+	expected := []string{
+		"(1, user1, person1@example.com)\n",
+		"(2, user2, person2@example.com)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestTransactionCommit failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	script := []string{"insert 1 user1 person1@example.com", "begin"}
+	for i := 2; i <= 300; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, "update set username = changed where id = 1", "delete where id <= 150", "rollback", "select", ".btree", ".exit")
+	expected := []string{
+		"(1, user1, person1@example.com)\n",
+		"Executed.\n",
+		"Tree:\n",
+		"- leaf (size 1)\n",
+		"  - 1\n",
+	}
+
+	for _, journalMode := range []laurel.JournalMode{laurel.JOURNAL_MODE_ROLLBACK, laurel.JOURNAL_MODE_WAL} {
+		opts := []laurel.Option{laurel.WithJournalMode(journalMode), laurel.WithCacheSize(laurel.MIN_CACHE_SIZE)}
+		result := runScript(t, script, true, opts...)
+		if !strings.HasSuffix(strings.Join(result, ""), strings.Join(expected, "")) {
+			t.Errorf("TestTransactionRollback failed in journal mode %d, got: %v, want: %v", journalMode, result, expected)
+		}
+
+		reopened := runScript(t, []string{"select", ".exit"}, false, opts...)
+		if !reflect.DeepEqual(reopened, expected[:2]) {
+			t.Errorf("TestTransactionRollback failed in journal mode %d after reopening, got: %v, want: %v",
+				journalMode, reopened, expected[:2])
+		}
+	}
+}
+
+func TestClosingAbandonsTransaction(t *testing.T) {
+	runScript(t, []string{
+		"insert 1 user1 person1@example.com",
+		"begin",
+		"insert 2 user2 person2@example.com",
+		".exit",
+	}, true)
+	result := runScript(t, []string{"select", ".exit"}, false)
+	expected := []string{
+		"(1, user1, person1@example.com)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestClosingAbandonsTransaction failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestTransactionErrorMessages(t *testing.T) {
+	result := runScript(t, []string{
+		"commit",
+		"rollback",
+		"begin",
+		"begin",
+		"commit",
+		".exit",
+	}, true)
+	expected := []string{
+		"Error: No transaction is active.\n",
+		"Error: No transaction is active.\n",
+		"Executed.\n",
+		"Error: Cannot start a transaction within a transaction.\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestTransactionErrorMessages failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestSavepointPartialRollback(t *testing.T) {
+	script := make([]string, 0)
+	for i := 1; i <= 5; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, "begin")
+	for i := 6; i <= 50; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, "savepoint a")
+	for i := 51; i <= 120; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, "delete where id <= 20", "savepoint b")
+	for i := 121; i <= 150; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script,
+		"rollback to b",
+		"select",
+		"insert 200 user200 person200@example.com",
+		"rollback to savepoint a",
+		"savepoint c",
+		"update set username = changed where id = 2",
+		"release c",
+		"commit",
+		"select",
+		".exit",
+	)
+
+	afterB, afterA := make([]string, 0), make([]string, 0)
+	for i := 21; i <= 120; i++ {
+		afterB = append(afterB, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", i, i, i))
+	}
+	for i := 1; i <= 50; i++ {
+		afterA = append(afterA, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", i, i, i))
+	}
+	afterA[1] = "(2, changed, person2@example.com)\n"
+	// select, insert, rollback to a, savepoint c, update, release c, commit
+	expected := append(append(append(afterB, "Executed.\n", "Executed.\n", "Executed.\n", "Executed.\n",
+		"Rows affected: 1\n", "Executed.\n", "Executed.\n", "Executed.\n"), afterA...), "Executed.\n")
+
+	for _, journalMode := range []laurel.JournalMode{laurel.JOURNAL_MODE_ROLLBACK, laurel.JOURNAL_MODE_WAL} {
+		opts := []laurel.Option{
+			laurel.WithJournalMode(journalMode),
+			laurel.WithCacheSize(laurel.MIN_CACHE_SIZE),
+			laurel.WithInternalNodeMaxCells(3),
+		}
+		result := runScript(t, script, true, opts...)
+		// Everything before the first select is an insert, delete or savepoint
+		start := slices.Index(result, afterB[0])
+		if start < 0 || !reflect.DeepEqual(result[start:], expected) {
+			t.Errorf("TestSavepointPartialRollback failed in journal mode %d, got: %v, want: %v", journalMode, result, expected)
+		}
+
+		reopened := runScript(t, []string{"select", ".exit"}, false, opts...)
+		if !reflect.DeepEqual(reopened, append(afterA, "Executed.\n")) {
+			t.Errorf("TestSavepointPartialRollback failed in journal mode %d after reopening, got: %v, want: %v",
+				journalMode, reopened, append(afterA, "Executed.\n"))
+		}
+	}
+}
+
+func TestSavepointOutsideTransaction(t *testing.T) {
+	result := runScript(t, []string{
+		"savepoint s",
+		"insert 1 user1 person1@example.com",
+		"release s",
+		"savepoint s",
+		"insert 2 user2 person2@example.com",
+		"rollback to s",
+		"release savepoint s",
+		"release s",
+		"rollback to nope",
+		"rollback s",
+		"select",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Error: No such savepoint: s.\n",
+		"Error: No such savepoint: nope.\n",
+		"Syntax error at line 1, column 10: unexpected \"s\" after the end of the statement.\n",
+		"(1, user1, person1@example.com)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestSavepointOutsideTransaction failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestOpeningIncompatibleFiles(t *testing.T) {
+	corruptions := []struct {
+		offset int
+		value  []byte
+		err    error
+	}{
+		{0, []byte("hello, world"), laurel.ErrNotADatabase},
+		{laurel.DB_HEADER_VERSION_OFFSET, binary.BigEndian.AppendUint32(nil, laurel.DB_FORMAT_VERSION), laurel.ErrByteOrder},
+		{laurel.DB_HEADER_VERSION_OFFSET, binary.NativeEndian.AppendUint32(nil, laurel.DB_FORMAT_VERSION+1), laurel.ErrUnsupportedFormat},
+		{laurel.DB_HEADER_PAGE_SIZE_OFFSET, binary.NativeEndian.AppendUint32(nil, 1000), laurel.ErrPageSize},
+	}
+	for _, corruption := range corruptions {
+		runScript(t, []string{"insert 1 user1 person1@example.com", ".exit"}, true)
+		file, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteAt(corruption.value, int64(corruption.offset)); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		_, err = laurel.DBopen("test.db")
+		var headerErr *laurel.HeaderError
+		if !errors.Is(err, corruption.err) || !errors.As(err, &headerErr) || headerErr.Filename != "test.db" {
+			t.Errorf("TestOpeningIncompatibleFiles failed, got: %v, want: %v", err, corruption.err)
+		}
+	}
+
+	// A file too short to hold a page is not mistaken for a new database
+	if err := os.WriteFile("test.db", []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := laurel.DBopen("test.db"); !errors.Is(err, laurel.ErrNotADatabase) {
+		t.Errorf("TestOpeningIncompatibleFiles failed, got: %v, want: %v", err, laurel.ErrNotADatabase)
+	}
+}
+
+func TestCommitsIncrementChangeCounter(t *testing.T) {
+	changeCounter := func() uint32 {
+		contents, err := os.ReadFile("test.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return binary.NativeEndian.Uint32(contents[laurel.DB_HEADER_CHANGE_COUNTER_OFFSET:])
+	}
+
+	runScript(t, []string{"insert 1 user1 person1@example.com", ".exit"}, true)
+	before := changeCounter()
+	runScript(t, []string{
+		"insert 2 user2 person2@example.com",
+		"select",
+		"begin",
+		"insert 3 user3 person3@example.com",
+		"insert 4 user4 person4@example.com",
+		"commit",
+		"begin",
+		"insert 5 user5 person5@example.com",
+		"rollback",
+		".exit",
+	}, false)
+	if after := changeCounter(); after != before+2 {
+		t.Errorf("TestCommitsIncrementChangeCounter failed, got: %v, want: %v", after, before+2)
+	}
+}
+
+func TestCorruptPageIsReported(t *testing.T) {
+	insertCommands := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	runScript(t, append(insertCommands, ".exit"), true, laurel.WithLeafNodeMaxCells(13))
+
+	// Flip a bit in unused space of the left leaf (page 4), which select reads first
+	file, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, 4*4096+2000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{b[0] ^ 1}, 4*4096+2000); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	result := runScript(t, []string{
+		"select",
+		"insert 15 user15 person15@example.com",
+		"delete where id = 1",
+		".exit",
+	}, false)
+	expected := []string{
+		"Error: page 4 is corrupt.\n",
+		"Executed.\n",
+		"Error: page 4 is corrupt.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCorruptPageIsReported failed, got: %v, want: %v", result, expected)
+	}
+
+	// The bit flip did not touch any row, so skipping verification reads them all
+	result = runScript(t, []string{"select", ".exit"}, false, laurel.WithSkipChecksumVerification(true))
+	expected = make([]string, 0)
+	for i := 1; i <= 15; i++ {
+		expected = append(expected, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", i, i, i))
+	}
+	expected = append(expected, "Executed.\n")
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCorruptPageIsReported failed without verification, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestCorruptPageInTransaction(t *testing.T) {
+	insertCommands := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	runScript(t, append(insertCommands, ".exit"), true, laurel.WithLeafNodeMaxCells(13))
+
+	// Flip a bit in unused space of the right leaf (page 3), holding ids 8 to 14
+	file, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, 3*4096+2000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{b[0] ^ 1}, 3*4096+2000); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// Deleting id 1 takes it out of the left leaf, then merges the leaf with
+	// the right one, and only that delete is undone
+	result := runScript(t, []string{
+		"begin",
+		"update set username = changed where id = 2",
+		"delete where id = 1",
+		"select * from users limit 7",
+		"commit",
+		".exit",
+	}, false)
+	rows := make([]string, 0)
+	for i := 1; i <= 7; i++ {
+		rows = append(rows, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", i, i, i))
+	}
+	rows[1] = "(2, changed, person2@example.com)\n"
+	expected := append(append([]string{
+		"Executed.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"Error: page 3 is corrupt.\n",
+	}, rows...), "Executed.\n", "Executed.\n")
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCorruptPageInTransaction failed, got: %v, want: %v", result, expected)
+	}
+
+	result = runScript(t, []string{"select * from users limit 7", ".exit"}, false)
+	if !reflect.DeepEqual(result, append(rows, "Executed.\n")) {
+		t.Errorf("TestCorruptPageInTransaction failed after commit, got: %v, want: %v", result, append(rows, "Executed.\n"))
+	}
+}
+
+func TestIntegrityCheckAfterManyChanges(t *testing.T) {
+	script := make([]string, 0)
+	for i := 0; i < 301; i++ {
+		id := i * 11 % 301
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", id, id, id))
+	}
+	script = append(script,
+		"delete where id between 40 and 180",
+		"update set username = changed where id = 200",
+		"delete where id > 250",
+	)
+	for i := 1000; i < 1100; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, ".check", ".exit")
+
+	result := runScript(t, script, true, laurel.WithInternalNodeMaxCells(3), laurel.WithCacheSize(laurel.MIN_CACHE_SIZE))
+	if len(result) == 0 || result[len(result)-1] != "ok\n" {
+		t.Errorf("TestIntegrityCheckAfterManyChanges failed, got: %v, want: %v", result[max(len(result)-5, 0):], "ok\n")
+	}
+}
+
+func TestIntegrityCheckReportsAllProblems(t *testing.T) {
+	insertCommands := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	runScript(t, append(insertCommands, ".check", ".exit"), true, laurel.WithLeafNodeMaxCells(13))
+
+	// Root internal node at page 2 with key 7, left leaf page 4, right leaf page 3
+	file, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The keys of the first two cells of page 4 are found through its cell pointers
+	pointers := make([]byte, 2*laurel.LEAF_NODE_CELL_POINTER_SIZE)
+	if _, err := file.ReadAt(pointers, 4*4096+laurel.LEAF_NODE_HEADER_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	corruptions := []struct {
+		pageNum uint32
+		offset  uint32
+		value   uint32
+	}{
+		{4, uint32(binary.NativeEndian.Uint16(pointers)) + laurel.LEAF_NODE_KEY_OFFSET, 2},
+		{4, uint32(binary.NativeEndian.Uint16(pointers[laurel.LEAF_NODE_CELL_POINTER_SIZE:])) + laurel.LEAF_NODE_KEY_OFFSET, 1},
+		{4, laurel.LEAF_NODE_NEXT_LEAF_OFFSET, 0},
+		{3, laurel.PARENT_POINTER_OFFSET, 5},
+		{2, laurel.INTERNAL_NODE_HEADER_SIZE + laurel.INTERNAL_NODE_CHILD_SIZE, 8},
+	}
+	for _, corruption := range corruptions {
+		value := binary.NativeEndian.AppendUint32(nil, corruption.value)
+		if _, err := file.WriteAt(value, int64(corruption.pageNum*4096+corruption.offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	result := runScript(t, []string{".check", ".exit"}, false, laurel.WithSkipChecksumVerification(true))
+	expected := []string{
+		"page 4: key 1 at cell 1 is not greater than key 2 before it\n",
+		"page 2: key 8 at cell 0 does not match max key 7 of child 4\n",
+		"page 3: parent pointer is 5, want 2\n",
+		"page 3: key 8 at cell 0 is outside the range of its parent\n",
+		"page 4: next leaf is 0, want 3\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestIntegrityCheckReportsAllProblems failed, got: %v, want: %v", result, expected)
+	}
+
+	// Without skipping verification the tampered pages are reported as corrupt
+	result = runScript(t, []string{".check", ".exit"}, false)
+	expected = []string{
+		"page 2 is corrupt\n",
+		"page 3: never used\n",
+		"page 4: never used\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestIntegrityCheckReportsAllProblems failed with verification, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestPageSizeIsChosenAtCreation(t *testing.T) {
+	for _, pageSize := range []uint32{laurel.MIN_PAGE_SIZE, 1024, laurel.MAX_PAGE_SIZE} {
+		script := make([]string, 0)
+		expected := make([]string, 0)
+		for i := 1; i <= 200; i++ {
+			id := i * 7 % 201
+			script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", id, id, id))
+			expected = append(expected, fmt.Sprintf("(%d, user%d, person%d@example.com)\n", i, i, i))
+		}
+		script = append(script, "delete where id > 150", ".check", ".exit")
+		expected = append(expected[:150], "Executed.\n", "ok\n")
+
+		result := runScript(t, script, true, laurel.WithPageSize(pageSize))
+		if len(result) == 0 || result[len(result)-1] != "ok\n" {
+			t.Errorf("TestPageSizeIsChosenAtCreation failed with page size %d, got: %v", pageSize, result)
+		}
+		fileInfo, err := os.Stat("test.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fileInfo.Size()%int64(pageSize) != 0 {
+			t.Errorf("TestPageSizeIsChosenAtCreation failed, file size %d is not a multiple of page size %d", fileInfo.Size(), pageSize)
+		}
+
+		// The page size of an existing database comes from its header
+		result = runScript(t, []string{"select", ".check", ".exit"}, false, laurel.WithPageSize(laurel.DEFAULT_PAGE_SIZE))
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("TestPageSizeIsChosenAtCreation failed with page size %d, got: %v, want: %v", pageSize, result, expected)
+		}
+	}
+
+	if _, err := laurel.DBopen("test.db", laurel.WithPageSize(1000)); !errors.Is(err, laurel.ErrPageSize) {
+		t.Errorf("TestPageSizeIsChosenAtCreation failed, got: %v, want: %v", err, laurel.ErrPageSize)
+	}
+}
+
+func TestStatementSyntax(t *testing.T) {
+	result := runScript(t, []string{
+		"insert  1\tuser1   person1@example.com;",
+		"INSERT 2 'John O''Brien' \"john smith@example.com\" -- a comment",
+		"/* block\ncomment */ insert 3 user3 'person3@example.com' ;",
+		"",
+		"  ;  ",
+		"Select;",
+		"delete\n  where id\n  between 2 and 2;",
+		"select ;",
+		"insert 4 'user4 person4@example.com",
+		"insert 4 user4 #person4",
+		"insert 4 user4",
+		"delete where id = 1 and",
+		"update set username = bob\nwhere id >= 1",
+		"/* unterminated",
+		"insert 4x user4 person4@example.com",
+		"selectall",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"(1, user1, person1@example.com)\n",
+		"(2, John O'Brien, john smith@example.com)\n",
+		"(3, user3, person3@example.com)\n",
+		"Executed.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"(1, user1, person1@example.com)\n",
+		"(3, user3, person3@example.com)\n",
+		"Executed.\n",
+		"Syntax error at line 1, column 10: unterminated quoted string.\n",
+		"Syntax error at line 1, column 16: unexpected character '#'.\n",
+		"Error: 2 values for 3 columns.\n",
+		"Syntax error at line 1, column 24: expected a value, got end of input.\n",
+		"Syntax error at line 2, column 10: update must select its row with where id = <id>.\n",
+		"Syntax error at line 1, column 1: unterminated comment.\n",
+		"Syntax error at line 1, column 8: malformed number \"4x\".\n",
+		"Unrecognized keyword at start of 'selectall'.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestStatementSyntax failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestBareWordValues(t *testing.T) {
+	result := runScript(t, []string{
+		"insert 1 key to",
+		"insert 2 Order first.last+tag@example.com;",
+		"update set username = values, email = a.b@c.d where id = 1",
+		"select",
+		"select * from users where email = x.y",
+		"select * from users where email = 'a.b@c.d'",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"(1, values, a.b@c.d)\n",
+		"(2, Order, first.last+tag@example.com)\n",
+		"Executed.\n",
+		"Syntax error at line 1, column 36: unexpected \".\" after the end of the statement.\n",
+		"(1, values, a.b@c.d)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestBareWordValues failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	result := runScript(t, []string{
+		"create table items (name text, price real, qty int, data blob(4)) /* stock */ ;",
+		"insert into items values ('apple', 1.5, 3, x'00ff')",
+		"insert into items (qty, name) values (7, 'pear')",
+		"insert into items values ('banana', 2, 4, x'01')",
+		"insert into items values ('kiwi', 'cheap', 1, null)",
+		"insert into items values ('kiwi', 1, 1, x'0102030405')",
+		"insert into items (nope) values (1)",
+		"insert into items values ('kiwi')",
+		"delete from items where rowid = 2",
+		"insert 1 user1 person1@example.com",
+		"select * from nope",
+		"create table USERS (id integer primary key)",
+		"insert into laurel_schema values ('table', 'x', 5, 'create table x (a int)')",
+		".tables",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Error: cannot store 'cheap' in REAL column price.\n",
+		"String is too long.\n",
+		"Error: no such column: nope.\n",
+		"Error: 1 values for 4 columns.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Error: no such table: nope.\n",
+		"Error: Table USERS already exists.\n",
+		"Error: table laurel_schema may not be modified.\n",
+		"items\n",
+		"users\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCreateTable failed, got: %v, want: %v", result, expected)
+	}
+
+	// The tables are kept in the catalog
+	result = runScript(t, []string{"select * from items", "select", "select * from laurel_schema", ".check", ".exit"}, false)
+	expected = []string{
+		"(apple, 1.5, 3, x'00ff')\n",
+		"(banana, 2.0, 4, x'01')\n",
+		"Executed.\n",
+		"(1, user1, person1@example.com)\n",
+		"Executed.\n",
+		"(table, users, 2, " + laurel.DEFAULT_SCHEMA + ")\n",
+		"(table, items, 3, create table items (name text, price real, qty int, data blob(4)))\n",
+		"Executed.\n",
+		"ok\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCreateTable failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestCreateTableWithPrimaryKey(t *testing.T) {
+	result := runScript(t, []string{
+		"create table t (k integer primary key, v text)",
+		"insert into t values (null, 'a')",
+		"insert into t values (10, 'b')",
+		"insert into t (v) values ('c')",
+		"insert into t values (10, 'd')",
+		"update t set v = 'changed' where k = 10",
+		"select * from t",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Error: Duplicate key.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"(1, a)\n",
+		"(10, changed)\n",
+		"(11, c)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCreateTableWithPrimaryKey failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestCreateTableRollsBack(t *testing.T) {
+	result := runScript(t, []string{
+		"begin",
+		"create table t (a text)",
+		"insert into t values ('x')",
+		"rollback",
+		"insert 1 user1 person1@example.com",
+		"select * from t",
+		"select * from users",
+		".check",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Error: no such table: t.\n",
+		"(1, user1, person1@example.com)\n",
+		"Executed.\n",
+		"ok\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCreateTableRollsBack failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestCreateTableErrorMessages(t *testing.T) {
+	result := runScript(t, []string{
+		"create table t (a varchar)",
+		"create table t (a text, A int)",
+		"create table t (a text primary key)",
+		"create table t (a int(3))",
+		"create table t (a int, b)",
+		".exit",
+	}, true)
+	expected := []string{
+		"Syntax error at line 1, column 19: unknown column type \"varchar\".\n",
+		"Syntax error at line 1, column 25: duplicate column \"A\".\n",
+		"Syntax error at line 1, column 17: only an INTEGER column can be the primary key.\n",
+		"Syntax error at line 1, column 23: invalid size for column \"a\".\n",
+		"Syntax error at line 1, column 25: expected a name, got \")\".\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestCreateTableErrorMessages failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestDropTable(t *testing.T) {
+	script := []string{"create table items (name text, qty int)"}
+	for i := 1; i <= 30; i++ {
+		script = append(script, fmt.Sprintf("insert into items values ('item%d', %d)", i, i))
+	}
+	script = append(script,
+		"drop table items",
+		"select * from items",
+		"drop table items",
+		"drop table laurel_schema",
+		".tables",
+		".freelist",
+		".check",
+		".exit",
+	)
+	result := runScript(t, script, true, laurel.WithLeafNodeMaxCells(13))
+	expected := []string{
+		"Executed.\n",
+		"Error: no such table: items.\n",
+		"Error: no such table: items.\n",
+		"Error: table laurel_schema may not be modified.\n",
+		"users\n",
+		"Freelist (5 pages):\n",
+		"- trunk 5 (size 4)\n",
+		"  - 4\n", "  - 6\n", "  - 7\n", "  - 3\n",
+		"ok\n",
+	}
+	if strings.Join(result[31:], "") != strings.Join(expected, "") {
+		t.Errorf("TestDropTable failed, got: %v, want: %v", result[31:], expected)
+	}
+
+	// The pages of the dropped table are reused, and a drop can roll back
+	result = runScript(t, []string{
+		"create table t (a int)",
+		"insert into t values (1)",
+		"begin",
+		"drop table t",
+		"select * from t",
+		"rollback",
+		"select * from t",
+		".freelist",
+		".check",
+		".exit",
+	}, false)
+	expected = []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Error: no such table: t.\n",
+		"Executed.\n",
+		"(1)\n",
+		"Executed.\n",
+		"Freelist (4 pages):\n",
+		"- trunk 5 (size 3)\n",
+		"  - 4\n", "  - 6\n", "  - 7\n",
+		"ok\n",
+	}
+	if strings.Join(result, "") != strings.Join(expected, "") {
+		t.Errorf("TestDropTable failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	result := runScript(t, []string{
+		"insert 1 user1 person1@example.com",
+		"alter table users rename to people",
+		"select",
+		"alter table people add column age int default 18",
+		"alter table people add nickname text(8)",
+		"insert into people (id, username, email) values (2, 'user2', 'person2@example.com')",
+		"insert into people values (3, 'user3', 'person3@example.com', 40, 'three')",
+		"update people set age = 41 where id = 1",
+		"select * from people",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Error: no such table: users.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"(1, user1, person1@example.com, 41, NULL)\n",
+		"(2, user2, person2@example.com, 18, NULL)\n",
+		"(3, user3, person3@example.com, 40, three)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestAlterTable failed, got: %v, want: %v", result, expected)
+	}
+
+	result = runScript(t, []string{
+		"create table t (a int)",
+		"alter table people rename to T",
+		"alter table people rename to laurel_schema",
+		"alter table laurel_schema rename to x",
+		"alter table people add column age int",
+		"alter table people add column k integer primary key",
+		"alter table people add column b int default 'x'",
+		"alter table people add column b text(2) default 'abc'",
+		"alter table people drop column age",
+		"alter table t rename to \"my table\"",
+		"select * from laurel_schema",
+		".check",
+		".exit",
+	}, false)
+	expected = []string{
+		"Executed.\n",
+		"Error: Table T already exists.\n",
+		"Error: Table laurel_schema already exists.\n",
+		"Error: table laurel_schema may not be modified.\n",
+		"Syntax error at line 1, column 31: duplicate column \"age\".\n",
+		"Syntax error at line 1, column 31: cannot add a primary key column.\n",
+		"Syntax error at line 1, column 45: cannot store 'x' in INTEGER column b.\n",
+		"Syntax error at line 1, column 49: default of column \"b\" is too long.\n",
+		"Syntax error at line 1, column 20: expected \"rename\" or \"add\", got \"drop\".\n",
+		"Executed.\n",
+		"(table, people, 2, create table people (id integer primary key, username text(32), email text(255), age integer default 18, nickname text(8)))\n",
+		"(table, my table, 3, create table \"my table\" (a integer))\n",
+		"Executed.\n",
+		"ok\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestAlterTable failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestSlottedLeafPages(t *testing.T) {
+	script := make([]string, 0)
+	for i := 1; i <= 100; i++ {
+		script = append(script, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	script = append(script, ".btree")
+	longEmail := strings.Repeat("e", 200) + "@example.com"
+	for i := 1; i <= 100; i += 2 {
+		script = append(script, fmt.Sprintf("update set email = %s where id = %d", longEmail, i))
+	}
+	script = append(script, "delete where id between 20 and 60", ".check", "select", ".exit")
+
+	result := runScript(t, script, true)
+	// Short rows pack densely, a hundred of them fit in a single leaf
+	if result[100] != "Tree:\n" || result[101] != "- leaf (size 100)\n" {
+		t.Errorf("TestSlottedLeafPages failed, got: %v, want: %v", result[100:102], []string{"Tree:\n", "- leaf (size 100)\n"})
+	}
+	expected := []string{"Rows affected: 41\n", "Executed.\n", "ok\n"}
+	for i := 1; i <= 100; i++ {
+		if i >= 20 && i <= 60 {
+			continue
+		}
+		email := fmt.Sprintf("person%d@example.com", i)
+		if i%2 == 1 {
+			email = longEmail
+		}
+		expected = append(expected, fmt.Sprintf("(%d, user%d, %s)\n", i, i, email))
+	}
+	expected = append(expected, "Executed.\n")
+	rest := result[len(result)-len(expected):]
+	if !reflect.DeepEqual(rest, expected) {
+		t.Errorf("TestSlottedLeafPages failed, got: %v, want: %v", rest, expected)
+	}
+
+}
+
+func TestOverflowPages(t *testing.T) {
+	body := strings.Repeat("lorem ipsum ", 1000)
+	avatar := strings.Repeat("c0ffee", 2000)
+	result := runScript(t, []string{
+		"create table docs (id integer primary key, body text, avatar blob)",
+		"insert into docs values (1, '" + body + "', x'" + avatar + "')",
+		"insert into docs values (2, '" + body[:3000] + "', NULL)",
+		"insert into docs values (3, 'short', x'00')",
+		"select * from docs",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"(1, " + body + ", x'" + avatar + "')\n",
+		"(2, " + body[:3000] + ", NULL)\n",
+		"(3, short, x'00')\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestOverflowPages failed, got: %v, want: %v", result, expected)
+	}
+
+	// Rewritten and deleted records give their overflow pages back, which
+	// later records reuse
+	result = runScript(t, []string{
+		"update docs set avatar = NULL where id = 1",
+		".freelist",
+		"insert into docs values (4, '" + body[:9000] + "', NULL)",
+		"delete from docs where id = 2",
+		".freelist",
+		".check",
+		"select * from docs",
+		".exit",
+	}, false)
+	expected = []string{
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"Freelist (1 pages):\n",
+		"- trunk 7 (size 0)\n",
+		"Executed.\n",
+		"Rows affected: 1\n",
+		"Executed.\n",
+		"Freelist (1 pages):\n",
+		"- trunk 8 (size 0)\n",
+		"ok\n",
+		"(1, " + body + ", NULL)\n",
+		"(3, short, x'00')\n",
+		"(4, " + body[:9000] + ", NULL)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestOverflowPages failed, got: %v, want: %v", result, expected)
+	}
+
+	// Dropping the table frees its overflow pages along with its leaves
+	result = runScript(t, []string{
+		"drop table docs",
+		".freelist",
+		".check",
+		".exit",
+	}, false)
+	got := strings.Join(result, "")
+	want := "Executed.\n" +
+		"Freelist (7 pages):\n" +
+		"- trunk 8 (size 6)\n" +
+		"  - 6\n  - 5\n  - 4\n  - 9\n  - 7\n  - 3\n" +
+		"ok\n"
+	if got != want {
+		t.Errorf("TestOverflowPages failed, got: %v, want: %v", got, want)
+	}
+}
+
+func TestBrokenOverflowChainIsReported(t *testing.T) {
+	body := strings.Repeat("a", 10000)
+	runScript(t, []string{
+		"create table docs (id integer primary key, body text)",
+		"insert into docs values (1, '" + body + "')",
+		".exit",
+	}, true)
+
+	// The chain is written from its end, so its first page (5) is the last
+	// one allocated after the leaf (3). Ending the chain there leaves the
+	// record short, which is the fault of page 5 rather than of the page it
+	// no longer points at.
+	file, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(make([]byte, laurel.OVERFLOW_NEXT_SIZE), 5*4096+laurel.OVERFLOW_NEXT_OFFSET); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	result := runScript(t, []string{"select * from docs", ".exit"}, false,
+		laurel.WithSkipChecksumVerification(true))
+	expected := []string{"Error: page 5 is corrupt.\n"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestBrokenOverflowChainIsReported failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestSelectWhere(t *testing.T) {
+	result := runScript(t, []string{
+		"create table items (id integer primary key, name text, price real, qty int)",
+		"insert into items values (1, 'apple', 0.5, 10)",
+		"insert into items values (2, 'banana', 0.25, NULL)",
+		"insert into items values (3, 'cherry', 3, 200)",
+		"insert into items values (4, 'date', NULL, 7)",
+		"insert into items values (5, 'elderberry', 12.75, 0)",
+		"select * from items where name = 'cherry'",
+		"select * from items where price > 1 and qty < 100",
+		"select * from items where price < 1 or qty = 7",
+		"select * from items where not (price < 1 or qty = 7)",
+		"select * from items where qty is null or price is null",
+		"select * from items where qty is not null and qty between 5 and 10",
+		"select * from items where id not between 2 and 4",
+		"select * from items where name in ('date', 'fig', 'apple')",
+		"select * from items where id not in (1, 2, NULL)",
+		"select * from items where qty != 10",
+		"select * from items where price = 3 and qty = '200'",
+		"select * from items where name >= 'c' and name < 'e'",
+		"select * from items where qty",
+		"select where id <= 1",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"(3, cherry, 3.0, 200)\n",
+		"Executed.\n",
+		"(5, elderberry, 12.75, 0)\n",
+		"Executed.\n",
+		"(1, apple, 0.5, 10)\n",
+		"(2, banana, 0.25, NULL)\n",
+		"(4, date, NULL, 7)\n",
+		"Executed.\n",
+		"(3, cherry, 3.0, 200)\n",
+		"(5, elderberry, 12.75, 0)\n",
+		"Executed.\n",
+		"(2, banana, 0.25, NULL)\n",
+		"(4, date, NULL, 7)\n",
+		"Executed.\n",
+		"(1, apple, 0.5, 10)\n",
+		"(4, date, NULL, 7)\n",
+		"Executed.\n",
+		"(1, apple, 0.5, 10)\n",
+		"(5, elderberry, 12.75, 0)\n",
+		"Executed.\n",
+		"(1, apple, 0.5, 10)\n",
+		"(4, date, NULL, 7)\n",
+		"Executed.\n",
+		"Executed.\n",
+		"(3, cherry, 3.0, 200)\n",
+		"(4, date, NULL, 7)\n",
+		"(5, elderberry, 12.75, 0)\n",
+		"Executed.\n",
+		"(3, cherry, 3.0, 200)\n",
+		"Executed.\n",
+		"(3, cherry, 3.0, 200)\n",
+		"(4, date, NULL, 7)\n",
+		"Executed.\n",
+		"(1, apple, 0.5, 10)\n",
+		"(3, cherry, 3.0, 200)\n",
+		"(4, date, NULL, 7)\n",
+		"Executed.\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestSelectWhere failed, got: %v, want: %v", result, expected)
+	}
+
+	result = runScript(t, []string{
+		"insert 1 user1 person1@example.com",
+		"insert 2 user2 person2@example.com",
+		"select where username = 'user2'",
+		"select where username = user2",
+		"select where email like 'x'",
+		"select where id not = 1",
+		"select where (id = 1",
+		"select where rowid = 1",
+		".exit",
+	}, true)
+	expected = []string{
+		"Executed.\n",
+		"Executed.\n",
+		"(2, user2, person2@example.com)\n",
+		"Executed.\n",
+		"Error: no such column: user2.\n",
+		"Syntax error at line 1, column 20: unexpected \"like\" after the end of the statement.\n",
+		"Syntax error at line 1, column 21: expected \"between\" or \"in\", got \"=\".\n",
+		"Syntax error at line 1, column 21: expected \")\", got end of input.\n",
+		"(1, user1, person1@example.com)\n",
+		"Executed.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestSelectWhere failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestSelectSeeksByKey(t *testing.T) {
+	insertCommands := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	runScript(t, append(insertCommands, ".exit"), true, laurel.WithLeafNodeMaxCells(13))
+
+	// Corrupt the left leaf (page 4), holding ids 1 to 7, so that reading
+	// it fails: only selects that seek past it can succeed
+	file, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, 4*4096+2000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{b[0] ^ 1}, 4*4096+2000); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	result := runScript(t, []string{
+		"select where id = 10",
+		"select where id between 9 and 11 and username != 'user10'",
+		"select where 13 <= id",
+		"select where id > 11.5 and id < 13",
+		"select where id in (8, 14) or id = '12'",
+		"select where id = 3",
+		"select where username = 'user10'",
+		"select where id > 10 or email is null",
+		".exit",
+	}, false)
+	expected := []string{
+		"(10, user10, person10@example.com)\n",
+		"Executed.\n",
+		"(9, user9, person9@example.com)\n",
+		"(11, user11, person11@example.com)\n",
+		"Executed.\n",
+		"(13, user13, person13@example.com)\n",
+		"(14, user14, person14@example.com)\n",
+		"Executed.\n",
+		"(12, user12, person12@example.com)\n",
+		"Executed.\n",
+		"(8, user8, person8@example.com)\n",
+		"(12, user12, person12@example.com)\n",
+		"(14, user14, person14@example.com)\n",
+		"Executed.\n",
+		"Error: page 4 is corrupt.\n",
+		"Error: page 4 is corrupt.\n",
+		"Error: page 4 is corrupt.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestSelectSeeksByKey failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	result := runScript(t, []string{
+		"insert 1 alice alice@example.com",
+		"insert 2 Bob bob@example.org",
+		"select id, upper(username) as u, length(email) from users",
+		"select username || ' <' || email || '>', id * 10 + 1, -id, id / 2, id % 2, 7 / 2.0 from users where id = 2",
+		"select 'x', NULL, 1 / 0, *, x'ab' from users where id = 1",
+		".headers on",
+		"select id, upper(username) u, length(email), id+1 from users",
+		"select * from users where id = 2",
+		".headers off",
+		"select lower(username), abs(id - 5), (id + 1) * 2 from users where id = 1",
+		"select id from",
+		"select nope(id) from users",
+		"select upper(id, 1) from users",
+		"select missing from users",
+		"select id, from users",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"(1, ALICE, 17)\n",
+		"(2, BOB, 15)\n",
+		"Executed.\n",
+		"(Bob <bob@example.org>, 21, -2, 1, 0, 3.5)\n",
+		"Executed.\n",
+		"(x, NULL, NULL, 1, alice, alice@example.com, x'ab')\n",
+		"Executed.\n",
+		"(id, u, length(email), id+1)\n",
+		"(1, ALICE, 17, 2)\n",
+		"(2, BOB, 15, 3)\n",
+		"Executed.\n",
+		"(id, username, email)\n",
+		"(2, Bob, bob@example.org)\n",
+		"Executed.\n",
+		"(alice, 4, 4)\n",
+		"Executed.\n",
+		"Syntax error at line 1, column 15: expected a name, got end of input.\n",
+		"Error: no such function: nope.\n",
+		"Error: wrong number of arguments to function upper().\n",
+		"Error: no such column: missing.\n",
+		"Syntax error at line 1, column 12: expected a value, got \"from\".\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestSelectColumns failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	result := runScript(t, []string{
+		"create table items (id integer primary key, name text, price real)",
+		"insert into items values (1, 'pear', 2)",
+		"insert into items values (2, 'apple', 0.5)",
+		"insert into items values (3, 'fig', NULL)",
+		"insert into items values (4, 'banana', 0.5)",
+		"select * from items order by name",
+		"select name, price from items order by price desc, name",
+		"select name, price * 2 as cost from items order by cost, id desc",
+		"select id from items order by length(name), name desc",
+		"select id, name from items where price > 0.25 order by id desc",
+		"select id from items order by id",
+		"select id from items order by nope",
+		"select * from items order name",
+		".exit",
+	}, true)
+	expected := []string{
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"Executed.\n",
+		"(2, apple, 0.5)\n",
+		"(4, banana, 0.5)\n",
+		"(3, fig, NULL)\n",
+		"(1, pear, 2.0)\n",
+		"Executed.\n",
+		"(pear, 2.0)\n",
+		"(apple, 0.5)\n",
+		"(banana, 0.5)\n",
+		"(fig, NULL)\n",
+		"Executed.\n",
+		"(fig, NULL)\n",
+		"(banana, 1.0)\n",
+		"(apple, 1.0)\n",
+		"(pear, 4.0)\n",
+		"Executed.\n",
+		"(3)\n",
+		"(1)\n",
+		"(2)\n",
+		"(4)\n",
+		"Executed.\n",
+		"(4, banana)\n",
+		"(2, apple)\n",
+		"(1, pear)\n",
+		"Executed.\n",
+		"(1)\n",
+		"(2)\n",
+		"(3)\n",
+		"(4)\n",
+		"Executed.\n",
+		"Error: no such column: nope.\n",
+		"Syntax error at line 1, column 27: expected \"by\", got \"name\".\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestOrderBy failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestOrderBySpillsToDisk(t *testing.T) {
+	script := make([]string, 0)
+	for i := 1; i <= 300; i++ {
+		id := i * 11 % 301
+		script = append(script, fmt.Sprintf("insert %d user%03d person%d@example.com", id, (id*13)%301, id))
+	}
+	script = append(script, "select id, username from users order by id % 3 desc, username", ".exit")
+	// Every row takes up more than the memory of the sort, which then spills
+	// each one to a run of its own and merges them in several passes
+	result := runScript(t, script, true, laurel.WithSortMemory(1))
+
+	type user struct {
+		id       int
+		username string
+	}
+	users := make([]user, 0)
+	for i := 1; i <= 300; i++ {
+		id := i * 11 % 301
+		users = append(users, user{id, fmt.Sprintf("user%03d", (id*13)%301)})
+	}
+	slices.SortStableFunc(users, func(a, b user) int {
+		if a.id%3 != b.id%3 {
+			return b.id%3 - a.id%3
+		}
+		return strings.Compare(a.username, b.username)
+	})
+	expected := make([]string, 0)
+	for _, u := range users {
+		expected = append(expected, fmt.Sprintf("(%d, %s)\n", u.id, u.username))
+	}
+	expected = append(expected, "Executed.\n")
+	if rest := result[300:]; !reflect.DeepEqual(rest, expected) {
+		t.Errorf("TestOrderBySpillsToDisk failed, got: %v, want: %v", rest, expected)
+	}
+
+	// Without a place for temporary files only sorts that fit in memory
+	// succeed, as do selects in key order, which need no sort
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	result = runScript(t, []string{
+		"select id from users where id in (23, 24) order by username",
+		"select id from users where id < 3 order by id",
+		".exit",
+	}, false, laurel.WithSortMemory(1))
+	expected = []string{
+		"(1)\n",
+		"(2)\n",
+		"Executed.\n",
+	}
+	if len(result) != 4 || !strings.HasPrefix(result[0], "Error: creating sort run: ") || !reflect.DeepEqual(result[1:], expected) {
+		t.Errorf("TestOrderBySpillsToDisk failed, got: %v, want: %v", result, append([]string{"Error: creating sort run: ..."}, expected...))
+	}
+	result = runScript(t, []string{"select id from users where id in (23, 24) order by username", ".exit"}, false)
+	expected = []string{"(24)\n", "(23)\n", "Executed.\n"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestOrderBySpillsToDisk failed, got: %v, want: %v", result, expected)
+	}
+}
+
+func TestLimitOffset(t *testing.T) {
+	insertCommands := make([]string, 0)
+	for i := 1; i <= 14; i++ {
+		insertCommands = append(insertCommands, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	result := runScript(t, append(insertCommands,
+		"select id from users limit 3",
+		"select id from users limit 2 offset 12",
+		"select id from users limit 12, 5",
+		"select id from users where id % 2 = 0 order by id desc limit 2 offset 1",
+		"select id from users order by username limit 3",
+		"select id from users limit 0",
+		"select id from users where id > 10 limit -1 offset -5",
+		"select limit 1",
+		"select id from users limit 'x'",
+		"select id from users limit 2 order by id",
+		".exit",
+	), true, laurel.WithLeafNodeMaxCells(13))
+	expected := []string{
+		"(1)\n", "(2)\n", "(3)\n", "Executed.\n",
+		"(13)\n", "(14)\n", "Executed.\n",
+		"(13)\n", "(14)\n", "Executed.\n",
+		"(12)\n", "(10)\n", "Executed.\n",
+		"(1)\n", "(10)\n", "(11)\n", "Executed.\n",
+		"Executed.\n",
+		"(11)\n", "(12)\n", "(13)\n", "(14)\n", "Executed.\n",
+		"(1, user1, person1@example.com)\n", "Executed.\n",
+		"Syntax error at line 1, column 28: expected an integer, got 'x'.\n",
+		"Syntax error at line 1, column 30: unexpected \"order\" after the end of the statement.\n",
+	}
+	if rest := result[14 : 14+len(expected)]; !reflect.DeepEqual(rest, expected) {
+		t.Errorf("TestLimitOffset failed, got: %v, want: %v", rest, expected)
+	}
+
+	// Corrupt the right leaf (page 3), holding ids 8 to 14: a select that
+	// stops within the left leaf never reads it
+	file, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, 3*4096+2000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{b[0] ^ 1}, 3*4096+2000); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	result = runScript(t, []string{
+		"select id from users limit 7",
+		"select id from users where id > 5 limit 2",
+		"select id from users limit 8",
+		".exit",
+	}, false)
+	expected = []string{
+		"(1)\n", "(2)\n", "(3)\n", "(4)\n", "(5)\n", "(6)\n", "(7)\n", "Executed.\n",
+		"(6)\n", "(7)\n", "Executed.\n",
+		"(1)\n", "(2)\n", "(3)\n", "(4)\n", "(5)\n", "(6)\n", "(7)\n", "Error: page 3 is corrupt.\n",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("TestLimitOffset failed, got: %v, want: %v", result, expected)
+	}
+}
@@ -1,5 +1,9 @@
 package laurel
 
+import "slices"
+
+// Cursor points at a cell of a leaf node. The leaf stays pinned in the page
+// cache while the cursor is on it, so callers must close the cursor.
 type Cursor struct {
 	table      *Table
 	pageNum    uint32
@@ -7,80 +11,74 @@ type Cursor struct {
 	endOfTable bool // Indicates a position one past the last element
 }
 
-func (cursor *Cursor) leafNodeInsert(key uint32, value *Row) {
-	node := cursor.table.pager.getPage(cursor.pageNum)
+func (cursor *Cursor) close() {
+	cursor.table.pager.unpinPage(cursor.pageNum)
+}
 
-	numCells := *leafNodeNumCells(node)
-	if numCells >= LEAF_NODE_MAX_CELLS {
+func (cursor *Cursor) leafNodeInsert(key uint32, record []byte) {
+	// Spill the record first, the overflow pages may push the leaf out of
+	// the cache
+	cell := cursor.table.pager.makeLeafNodeCell(key, record)
+	node := cursor.table.pager.getWritablePage(cursor.pageNum)
+	if !cursor.table.pager.leafNodeHasRoom(node, cell) {
 		// Node full
-		cursor.leafNodeSplitAndInsert(key, value)
+		cursor.leafNodeSplitAndInsert(cell)
 		return
 	}
-
-	if cursor.cellNum < numCells {
-		// Make room for new cell
-		for i := numCells; i > cursor.cellNum; i-- {
-			copy(leafNodeCell(node, i)[:], leafNodeCell(node, i-1)[:])
-		}
-	}
-
-	*leafNodeNumCells(node) += 1
-	*leafNodeKey(node, cursor.cellNum) = key
-	serializeRow(value, leafNodeValue(node, cursor.cellNum)[:])
+	leafNodeInsertCell(node, cursor.cellNum, cell)
 }
 
-func (cursor *Cursor) leafNodeSplitAndInsert(key uint32, value *Row) {
-	// Create a new node and move half the cells over.
-	// Insert the new value in one of the two nodes.
-	// Update parent or create a new parent.
-	oldNode := cursor.table.pager.getPage(cursor.pageNum)
-	oldMax := getNodeMaxKey(oldNode)
-	newPageNum := cursor.table.pager.getUnusedPageNum()
-	newNode := cursor.table.pager.getPage(newPageNum)
+func (cursor *Cursor) leafNodeSplitAndInsert(cell []byte) {
+	// Create a new node and divide the cells, the new one included,
+	// between old (left) and new (right) nodes so that both are about
+	// as full. Update parent or create a new parent.
+	pager := cursor.table.pager
+	oldNode := pager.getWritablePage(cursor.pageNum)
+	oldMax := pager.getNodeMaxKey(oldNode)
+	cells := slices.Insert(leafNodeCells(oldNode), int(cursor.cellNum), cell)
+	leftCount := pager.leafNodeSplitPoint(cells)
+
+	newPageNum := pager.getUnusedPageNum()
+	newNode := pager.getWritablePage(newPageNum)
 	initializeLeafNode(newNode)
 	*nodeParent(newNode) = *nodeParent(oldNode)
 
 	*leafNodeNextLeaf(newNode) = *leafNodeNextLeaf(oldNode)
 	*leafNodeNextLeaf(oldNode) = newPageNum
-	// All existing keys plus new key should be divided
-	// evenly between old (left) and new (right) nodes.
-	// Starting from the right, move each key to correct position.
-	for i := int(LEAF_NODE_MAX_CELLS); i >= 0; i-- {
-		var destinationNode *[PAGE_SIZE]byte
-		if i >= int(LEAF_NODE_LEFT_SPLIT_COUNT) {
-			destinationNode = newNode
-		} else {
-			destinationNode = oldNode
-		}
-		indexWithinNode := i % int(LEAF_NODE_LEFT_SPLIT_COUNT)
-		destination := leafNodeCell(destinationNode, uint32(indexWithinNode))
-
-		if i == int(cursor.cellNum) {
-			serializeRow(value, leafNodeValue(destinationNode, uint32(indexWithinNode))[:])
-			*leafNodeKey(destinationNode, uint32(indexWithinNode)) = key
-		} else if i > int(cursor.cellNum) {
-			copy(destination[:], leafNodeCell(oldNode, uint32(i-1))[:])
-		} else {
-			copy(destination[:], leafNodeCell(oldNode, uint32(i))[:])
-		}
-	}
-
-	// Update cell count on both leaf nodes
-	*leafNodeNumCells(oldNode) = LEAF_NODE_LEFT_SPLIT_COUNT
-	*leafNodeNumCells(newNode) = LEAF_NODE_RIGHT_SPLIT_COUNT
+	leafNodeSetCells(oldNode, cells[:leftCount])
+	leafNodeSetCells(newNode, cells[leftCount:])
 
 	if isNodeRoot(oldNode) {
 		cursor.table.createNewRoot(newPageNum)
 	} else {
 		parentPageNum := *nodeParent(oldNode)
-		newMax := getNodeMaxKey(oldNode)
-		parent := cursor.table.pager.getPage(parentPageNum)
+		newMax := pager.getNodeMaxKey(oldNode)
+		parent := pager.getWritablePage(parentPageNum)
 		updateInternalNodeKey(parent, oldMax, newMax)
 		cursor.table.internalNodeInsert(parentPageNum, newPageNum)
 
 	}
 }
 
+func (cursor *Cursor) leafNodeDelete() {
+	// Remove the cell, then repair the separator keys and the fill
+	// factor of the nodes above it.
+	node := cursor.table.pager.getWritablePage(cursor.pageNum)
+	numCells := *leafNodeNumCells(node)
+	leafNodeRemoveCell(node, cursor.cellNum)
+
+	if isNodeRoot(node) {
+		return
+	}
+	// An emptied leaf has no max key, the rebalance below sets its separator
+	if cursor.cellNum == numCells-1 && numCells > 1 {
+		cursor.table.updateParentKey(cursor.pageNum)
+	}
+	if cursor.table.pager.leafNodeUnderfull(node) {
+		cursor.table.leafNodeRebalance(cursor.pageNum)
+	}
+}
+
 func (cursor *Cursor) cursorAdvance() {
 	pageNum := cursor.pageNum
 	node := cursor.table.pager.getPage(pageNum)
@@ -91,14 +89,29 @@ func (cursor *Cursor) cursorAdvance() {
 		if nextPageNum == 0 {
 			cursor.endOfTable = true
 		} else {
+			cursor.table.pager.unpinPage(cursor.pageNum)
+			cursor.table.pager.pinPage(nextPageNum)
 			cursor.pageNum = nextPageNum
 			cursor.cellNum = 0
 		}
 	}
 }
 
-func (cursor *Cursor) cursorValue() *[291]byte {
-	pageNum := cursor.pageNum
-	page := cursor.table.pager.getPage(pageNum)
-	return leafNodeValue(page, cursor.cellNum)
+// cursorValue returns the record the cursor points at.
+func (cursor *Cursor) cursorValue() []byte {
+	return cursor.table.pager.leafNodeRecord(cursor.pageNum, cursor.cellNum)
+}
+
+func (cursor *Cursor) cursorKey() uint32 {
+	page := cursor.table.pager.getPage(cursor.pageNum)
+	return *leafNodeKey(page, cursor.cellNum)
+}
+
+// cursorRow decodes the row the cursor points at.
+func (cursor *Cursor) cursorRow() []any {
+	row, ok := cursor.table.schema.decodeRow(cursor.cursorKey(), cursor.cursorValue())
+	if !ok {
+		panic(&ErrCorruptPage{PageNum: cursor.pageNum})
+	}
+	return row
 }
@@ -0,0 +1,605 @@
+package laurel
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Expression Evaluation
+ *
+ * Expressions are compiled against the schema of a table into evaluators,
+ * which compute their value for each row. Comparisons and the logical
+ * operators follow three-valued logic: they are 1 when true, 0 when false
+ * and NULL when an operand is NULL and the answer depends on it.
+ */
+
+// evaluator computes the value of an expression for the row stored under key.
+type evaluator func(key uint32, row []any) any
+
+// rowidColumn describes the hidden key of a table without an integer
+// primary key.
+var rowidColumn = Column{name: ROWID, ctype: COLUMN_INTEGER}
+
+// compileExpr compiles an expression over the columns of schema. Its errors
+// are *SyntaxError for malformed literals, and plain errors for names that
+// are not columns of the table.
+func compileExpr(expr Expr, schema *Schema) (evaluator, error) {
+	switch expr := expr.(type) {
+	case *ColumnRef:
+		index, _, err := resolveColumn(expr, schema)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 {
+			return func(key uint32, row []any) any { return int64(key) }, nil
+		}
+		return func(key uint32, row []any) any { return row[index] }, nil
+	case *BinaryExpr:
+		switch {
+		case expr.op == "and" || expr.op == "or":
+			return compileLogical(expr, schema)
+		case slices.Contains(comparisonOperators, expr.op):
+			return compileComparison(expr, schema)
+		default:
+			return compileArithmetic(expr, schema)
+		}
+	case *UnaryExpr:
+		return compileUnary(expr, schema)
+	case *FunctionCall:
+		return compileFunctionCall(expr, schema)
+	case *IsNullExpr:
+		operand, err := compileExpr(expr.expr, schema)
+		if err != nil {
+			return nil, err
+		}
+		return func(key uint32, row []any) any {
+			return boolValue((operand(key, row) == nil) != expr.not)
+		}, nil
+	case *BetweenExpr:
+		return compileBetween(expr, schema)
+	case *InExpr:
+		return compileIn(expr, schema)
+	default:
+		value, err := literalValue(expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(uint32, []any) any { return value }, nil
+	}
+}
+
+// resolveColumn finds the column a reference names. The index is -1 for
+// the rowid of a table without an integer primary key.
+func resolveColumn(ref *ColumnRef, schema *Schema) (int, *Column, error) {
+	index := schema.columnIndex(ref.name)
+	if index >= 0 {
+		return index, &schema.columns[index], nil
+	}
+	if strings.EqualFold(ref.name, ROWID) {
+		if schema.keyColumn >= 0 {
+			return schema.keyColumn, &schema.columns[schema.keyColumn], nil
+		}
+		return -1, &rowidColumn, nil
+	}
+	return 0, nil, fmt.Errorf("no such column: %s", ref.name)
+}
+
+// compileOperand compiles an operand that is compared with other. If other
+// is a column and the operand is not, its values are given the type of the
+// column first where they can be, so that "id = '5'" finds the row with id 5.
+func compileOperand(expr, other Expr, schema *Schema) (evaluator, error) {
+	operand, err := compileExpr(expr, schema)
+	if err != nil {
+		return nil, err
+	}
+	ref, ok := other.(*ColumnRef)
+	if _, isColumn := expr.(*ColumnRef); !ok || isColumn {
+		return operand, nil
+	}
+	_, column, err := resolveColumn(ref, schema)
+	if err != nil {
+		return nil, err
+	}
+	return func(key uint32, row []any) any { return applyAffinity(operand(key, row), column.ctype) }, nil
+}
+
+func compileLogical(expr *BinaryExpr, schema *Schema) (evaluator, error) {
+	left, err := compileExpr(expr.left, schema)
+	if err != nil {
+		return nil, err
+	}
+	right, err := compileExpr(expr.right, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	// The result is decided by either operand being decisive, false for
+	// and and true for or, otherwise NULL if either is NULL
+	decisive := expr.op == "or"
+	return func(key uint32, row []any) any {
+		leftValue, leftOk := truthValue(left(key, row))
+		if leftOk && leftValue == decisive {
+			return boolValue(decisive)
+		}
+		rightValue, rightOk := truthValue(right(key, row))
+		if rightOk && rightValue == decisive {
+			return boolValue(decisive)
+		}
+		if !leftOk || !rightOk {
+			return nil
+		}
+		return boolValue(!decisive)
+	}, nil
+}
+
+func compileUnary(expr *UnaryExpr, schema *Schema) (evaluator, error) {
+	operand, err := compileExpr(expr.expr, schema)
+	if err != nil {
+		return nil, err
+	}
+	switch expr.op {
+	case "not":
+		return func(key uint32, row []any) any {
+			value, ok := truthValue(operand(key, row))
+			if !ok {
+				return nil
+			}
+			return boolValue(!value)
+		}, nil
+	case "-":
+		return func(key uint32, row []any) any {
+			return arithmetic("-", int64(0), operand(key, row))
+		}, nil
+	default:
+		return operand, nil
+	}
+}
+
+// compileArithmetic compiles +, -, *, /, % and ||, which are NULL if either
+// operand is.
+func compileArithmetic(expr *BinaryExpr, schema *Schema) (evaluator, error) {
+	left, err := compileExpr(expr.left, schema)
+	if err != nil {
+		return nil, err
+	}
+	right, err := compileExpr(expr.right, schema)
+	if err != nil {
+		return nil, err
+	}
+	if expr.op == "||" {
+		return func(key uint32, row []any) any {
+			leftValue, rightValue := left(key, row), right(key, row)
+			if leftValue == nil || rightValue == nil {
+				return nil
+			}
+			return textValue(leftValue) + textValue(rightValue)
+		}, nil
+	}
+	return func(key uint32, row []any) any {
+		return arithmetic(expr.op, left(key, row), right(key, row))
+	}, nil
+}
+
+func compileFunctionCall(expr *FunctionCall, schema *Schema) (evaluator, error) {
+	function, ok := functions[strings.ToLower(expr.name)]
+	if !ok {
+		return nil, fmt.Errorf("no such function: %s", expr.name)
+	}
+	if len(expr.args) != function.numArgs {
+		return nil, fmt.Errorf("wrong number of arguments to function %s()", expr.name)
+	}
+	args := make([]evaluator, len(expr.args))
+	for i, arg := range expr.args {
+		var err error
+		if args[i], err = compileExpr(arg, schema); err != nil {
+			return nil, err
+		}
+	}
+	return func(key uint32, row []any) any {
+		values := make([]any, len(args))
+		for i, arg := range args {
+			values[i] = arg(key, row)
+		}
+		return function.call(values)
+	}, nil
+}
+
+func compileComparison(expr *BinaryExpr, schema *Schema) (evaluator, error) {
+	left, err := compileOperand(expr.left, expr.right, schema)
+	if err != nil {
+		return nil, err
+	}
+	right, err := compileOperand(expr.right, expr.left, schema)
+	if err != nil {
+		return nil, err
+	}
+	return func(key uint32, row []any) any {
+		leftValue, rightValue := left(key, row), right(key, row)
+		if leftValue == nil || rightValue == nil {
+			return nil
+		}
+		return boolValue(compareResult(expr.op, compareValues(leftValue, rightValue)))
+	}, nil
+}
+
+func compileBetween(expr *BetweenExpr, schema *Schema) (evaluator, error) {
+	operand, err := compileExpr(expr.expr, schema)
+	if err != nil {
+		return nil, err
+	}
+	low, err := compileOperand(expr.low, expr.expr, schema)
+	if err != nil {
+		return nil, err
+	}
+	high, err := compileOperand(expr.high, expr.expr, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	// "x between low and high" is "x >= low and x <= high"
+	return func(key uint32, row []any) any {
+		value := operand(key, row)
+		lowValue, highValue := low(key, row), high(key, row)
+		var aboveLow, belowHigh any
+		if value != nil && lowValue != nil {
+			aboveLow = boolValue(compareValues(value, lowValue) >= 0)
+		}
+		if value != nil && highValue != nil {
+			belowHigh = boolValue(compareValues(value, highValue) <= 0)
+		}
+		if aboveLow == int64(0) || belowHigh == int64(0) {
+			return boolValue(expr.not)
+		}
+		if aboveLow == nil || belowHigh == nil {
+			return nil
+		}
+		return boolValue(!expr.not)
+	}, nil
+}
+
+func compileIn(expr *InExpr, schema *Schema) (evaluator, error) {
+	operand, err := compileExpr(expr.expr, schema)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]evaluator, len(expr.values))
+	for i, value := range expr.values {
+		if values[i], err = compileOperand(value, expr.expr, schema); err != nil {
+			return nil, err
+		}
+	}
+
+	// A value that is not found is NULL rather than false if the list has a
+	// NULL, which could have been it
+	return func(key uint32, row []any) any {
+		value := operand(key, row)
+		if value == nil {
+			return nil
+		}
+		sawNull := false
+		for _, candidate := range values {
+			candidateValue := candidate(key, row)
+			if candidateValue == nil {
+				sawNull = true
+			} else if compareValues(value, candidateValue) == 0 {
+				return boolValue(!expr.not)
+			}
+		}
+		if sawNull {
+			return nil
+		}
+		return boolValue(expr.not)
+	}, nil
+}
+
+func compareResult(op string, comparison int) bool {
+	switch op {
+	case "=":
+		return comparison == 0
+	case "!=", "<>":
+		return comparison != 0
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	default:
+		return comparison >= 0
+	}
+}
+
+// storageClass orders values of different types: numbers sort before text,
+// and text before blobs.
+func storageClass(value any) int {
+	switch value.(type) {
+	case int64, float64:
+		return 0
+	case string:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// compareValues compares two values that are not NULL. Integers and reals
+// compare by their numeric value, text and blobs byte by byte.
+func compareValues(a, b any) int {
+	if classA, classB := storageClass(a), storageClass(b); classA != classB {
+		return classA - classB
+	}
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return compareInts(a, b)
+		case float64:
+			return compareIntFloat(a, b)
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return -compareIntFloat(b, a)
+		case float64:
+			return compareFloats(a, b)
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareIntFloat compares an integer with a real exactly, which converting
+// the integer to a real would not do beyond 2^53.
+func compareIntFloat(i int64, f float64) int {
+	if f < math.MinInt64 {
+		return 1
+	}
+	if f >= math.MaxInt64 {
+		return -1
+	}
+	truncated := math.Trunc(f)
+	if comparison := compareInts(i, int64(truncated)); comparison != 0 {
+		return comparison
+	}
+	return compareFloats(0, f-truncated)
+}
+
+// applyAffinity converts a value to the type of a column if it can be
+// without losing information: text that reads as a number to a number for
+// numeric columns, and numbers to text for text columns.
+func applyAffinity(value any, ctype ColumnType) any {
+	switch ctype {
+	case COLUMN_INTEGER, COLUMN_REAL:
+		s, ok := value.(string)
+		if !ok {
+			return value
+		}
+		s = strings.TrimSpace(s)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			if ctype == COLUMN_REAL {
+				return float64(i)
+			}
+			return i
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			if ctype == COLUMN_INTEGER && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+				return int64(f)
+			}
+			return f
+		}
+	case COLUMN_TEXT:
+		switch value.(type) {
+		case int64, float64:
+			return formatValue(value)
+		}
+	}
+	return value
+}
+
+// truthValue reads a value as a condition. ok is false for NULL, which is
+// neither true nor false. Text and blobs are true if they start with a
+// number other than zero.
+func truthValue(value any) (truth bool, ok bool) {
+	switch value := value.(type) {
+	case nil:
+		return false, false
+	case int64:
+		return value != 0, true
+	case float64:
+		return value != 0, true
+	case string:
+		return leadingNumber(value) != 0, true
+	case []byte:
+		return leadingNumber(string(value)) != 0, true
+	default:
+		return false, true
+	}
+}
+
+// leadingNumber returns the number that text starts with, 0 if none.
+func leadingNumber(text string) float64 {
+	text = strings.TrimLeft(text, " \t\n\r")
+	end := 0
+	for end < len(text) && strings.IndexByte("+-.0123456789eE", text[end]) >= 0 {
+		end++
+	}
+	for ; end > 0; end-- {
+		if f, err := strconv.ParseFloat(text[:end], 64); err == nil && !math.IsNaN(f) {
+			return f
+		}
+	}
+	return 0
+}
+
+// isTrue reports whether a where clause keeps a row, which it does not if
+// the clause is NULL.
+func isTrue(value any) bool {
+	truth, _ := truthValue(value)
+	return truth
+}
+
+func boolValue(b bool) any {
+	if b {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+// arithmetic applies one of +, -, *, / and % to two values, which are read
+// as numbers. Integers stay integers unless the result overflows, and
+// division by zero is NULL.
+func arithmetic(op string, a, b any) any {
+	if a == nil || b == nil {
+		return nil
+	}
+	a, b = numericValue(a), numericValue(b)
+	x, xIsInt := a.(int64)
+	y, yIsInt := b.(int64)
+	if xIsInt && yIsInt {
+		if result, ok := integerArithmetic(op, x, y); ok {
+			return result
+		}
+		if (op == "/" || op == "%") && y == 0 {
+			return nil
+		}
+	}
+
+	f, g := toFloat(a), toFloat(b)
+	switch op {
+	case "+":
+		return f + g
+	case "-":
+		return f - g
+	case "*":
+		return f * g
+	case "/":
+		if g == 0 {
+			return nil
+		}
+		return f / g
+	default:
+		// The remainder of the operands truncated to integers
+		remainder, ok := integerArithmetic("%", truncateToInt(f), truncateToInt(g))
+		if !ok {
+			return nil
+		}
+		return float64(remainder)
+	}
+}
+
+// truncateToInt drops the fraction of f, saturating at the bounds of int64.
+func truncateToInt(f float64) int64 {
+	switch {
+	case f <= math.MinInt64:
+		return math.MinInt64
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	default:
+		return int64(f)
+	}
+}
+
+// integerArithmetic returns false if the result does not fit in an int64,
+// or is undefined.
+func integerArithmetic(op string, x, y int64) (int64, bool) {
+	switch op {
+	case "+":
+		result := x + y
+		return result, (result > x) == (y > 0)
+	case "-":
+		result := x - y
+		return result, (result < x) == (y > 0)
+	case "*":
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+		result := x * y
+		return result, result/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64)
+	case "/":
+		if y == 0 || (x == math.MinInt64 && y == -1) {
+			return 0, false
+		}
+		return x / y, true
+	default:
+		if y == 0 {
+			return 0, false
+		}
+		if y == -1 {
+			return 0, true
+		}
+		return x % y, true
+	}
+}
+
+// numericValue reads a value as a number. Text and blobs are the number
+// they start with, or 0.
+func numericValue(value any) any {
+	switch value := value.(type) {
+	case string:
+		return textNumber(value)
+	case []byte:
+		return textNumber(string(value))
+	default:
+		return value
+	}
+}
+
+func textNumber(text string) any {
+	if i, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64); err == nil {
+		return i
+	}
+	f := leadingNumber(text)
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return int64(f)
+	}
+	return f
+}
+
+func toFloat(value any) float64 {
+	if i, ok := value.(int64); ok {
+		return float64(i)
+	}
+	return value.(float64)
+}
+
+// textValue reads a value that is not NULL as text.
+func textValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return formatValue(value)
+	}
+}
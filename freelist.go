@@ -0,0 +1,122 @@
+package laurel
+
+import "unsafe"
+
+/*
+ * Freelist Trunk Page Layout
+ *
+ * Free pages are chained from the database header through trunk pages. Each
+ * trunk lists a batch of free leaf pages, whose content is meaningless, and
+ * points at the next trunk. A trunk is itself free and is handed out once it
+ * has no leaves left.
+ */
+const (
+	FREELIST_TRUNK_NEXT_SIZE         = 4
+	FREELIST_TRUNK_NEXT_OFFSET       = COMMON_NODE_HEADER_SIZE
+	FREELIST_TRUNK_NUM_LEAVES_SIZE   = 4
+	FREELIST_TRUNK_NUM_LEAVES_OFFSET = FREELIST_TRUNK_NEXT_OFFSET + FREELIST_TRUNK_NEXT_SIZE
+	FREELIST_TRUNK_HEADER_SIZE       = COMMON_NODE_HEADER_SIZE + FREELIST_TRUNK_NEXT_SIZE + FREELIST_TRUNK_NUM_LEAVES_SIZE
+	FREELIST_TRUNK_LEAF_SIZE         = 4
+)
+
+func freelistTrunkNext(trunk []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&trunk[FREELIST_TRUNK_NEXT_OFFSET]))
+}
+
+func freelistTrunkNumLeaves(trunk []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&trunk[FREELIST_TRUNK_NUM_LEAVES_OFFSET]))
+}
+
+func freelistTrunkLeaf(trunk []byte, leafNum uint32) *uint32 {
+	offset := FREELIST_TRUNK_HEADER_SIZE + leafNum*FREELIST_TRUNK_LEAF_SIZE
+	return (*uint32)(unsafe.Pointer(&trunk[offset]))
+}
+
+func initializeFreelistTrunk(trunk []byte) {
+	setNodeType(trunk, NODE_FREELIST_TRUNK)
+	setNodeRoot(trunk, false)
+	*nodeParent(trunk) = 0
+	*freelistTrunkNext(trunk) = 0
+	*freelistTrunkNumLeaves(trunk) = 0
+}
+
+// getUnusedPageNum hands out a page from the freelist, or a page past the
+// end of the file when the freelist is empty.
+func (pager *Pager) getUnusedPageNum() uint32 {
+	header := pager.getWritablePage(DB_HEADER_PAGE_NUM)
+	trunkPageNum := *headerFreelistTrunk(header)
+	if trunkPageNum == 0 {
+		pageNum := pager.numPages
+		pager.numPages++
+		*headerPageCount(header) = pager.numPages
+		return pageNum
+	}
+
+	*headerFreelistCount(header) -= 1
+	trunk := pager.getWritablePage(trunkPageNum)
+	if numLeaves := *freelistTrunkNumLeaves(trunk); numLeaves > 0 {
+		*freelistTrunkNumLeaves(trunk) = numLeaves - 1
+		return *freelistTrunkLeaf(trunk, numLeaves-1)
+	}
+	*headerFreelistTrunk(header) = *freelistTrunkNext(trunk)
+	return trunkPageNum
+}
+
+// freePage puts a page that is no longer referenced on the freelist.
+func (pager *Pager) freePage(pageNum uint32) {
+	header := pager.getWritablePage(DB_HEADER_PAGE_NUM)
+	trunkPageNum := *headerFreelistTrunk(header)
+	*headerFreelistCount(header) += 1
+
+	if trunkPageNum != 0 {
+		trunk := pager.getWritablePage(trunkPageNum)
+		if numLeaves := *freelistTrunkNumLeaves(trunk); numLeaves < pager.freelistTrunkMaxLeaves {
+			*freelistTrunkLeaf(trunk, numLeaves) = pageNum
+			*freelistTrunkNumLeaves(trunk) = numLeaves + 1
+			return
+		}
+	}
+
+	// No room in the first trunk, the page becomes the new first trunk
+	trunk := pager.getWritablePage(pageNum)
+	initializeFreelistTrunk(trunk)
+	*freelistTrunkNext(trunk) = trunkPageNum
+	*headerFreelistTrunk(header) = pageNum
+}
+
+// freeTree puts every page of the B-tree rooted at pageNum on the freelist,
+// overflow pages included.
+func (pager *Pager) freeTree(pageNum uint32) {
+	node := pager.getPage(pageNum)
+	if getNodeType(node) == NODE_LEAF {
+		for _, cell := range leafNodeCells(node) {
+			pager.freeOverflow(cell)
+		}
+	}
+	if getNodeType(node) == NODE_INTERNAL {
+		numKeys := *internalNodeNumKeys(node)
+		children := make([]uint32, 0, numKeys+1)
+		for i := uint32(0); i <= numKeys; i++ {
+			children = append(children, *internalNodeChild(node, i))
+		}
+		for _, child := range children {
+			pager.freeTree(child)
+		}
+	}
+	pager.freePage(pageNum)
+}
+
+func (pager *Pager) printFreelist() {
+	header := pager.getPage(DB_HEADER_PAGE_NUM)
+	PrintMsgf("Freelist (%d pages):\n", *headerFreelistCount(header))
+	for trunkPageNum := *headerFreelistTrunk(header); trunkPageNum != 0; {
+		trunk := pager.getPage(trunkPageNum)
+		numLeaves := *freelistTrunkNumLeaves(trunk)
+		PrintMsgf("- trunk %d (size %d)\n", trunkPageNum, numLeaves)
+		for i := uint32(0); i < numLeaves; i++ {
+			indent(1)
+			PrintMsgf("- %d\n", *freelistTrunkLeaf(trunk, i))
+		}
+		trunkPageNum = *freelistTrunkNext(trunk)
+	}
+}
@@ -0,0 +1,55 @@
+package laurel
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// function is a scalar function that expressions can call. Like the
+// operators, functions are NULL when given NULL.
+type function struct {
+	numArgs int
+	call    func(args []any) any
+}
+
+// functions are found by their lower case name.
+var functions = map[string]function{
+	"upper":  {1, nullable(func(value any) any { return strings.ToUpper(textValue(value)) })},
+	"lower":  {1, nullable(func(value any) any { return strings.ToLower(textValue(value)) })},
+	"length": {1, nullable(length)},
+	"abs":    {1, nullable(abs)},
+}
+
+// nullable makes a function of one argument that is NULL for NULL.
+func nullable(f func(value any) any) func(args []any) any {
+	return func(args []any) any {
+		if args[0] == nil {
+			return nil
+		}
+		return f(args[0])
+	}
+}
+
+// length counts the characters of text, and the bytes of a blob.
+func length(value any) any {
+	if blob, ok := value.([]byte); ok {
+		return int64(len(blob))
+	}
+	return int64(utf8.RuneCountInString(textValue(value)))
+}
+
+func abs(value any) any {
+	switch value := numericValue(value).(type) {
+	case int64:
+		if value == math.MinInt64 {
+			return -float64(value)
+		}
+		if value < 0 {
+			return -value
+		}
+		return value
+	default:
+		return math.Abs(value.(float64))
+	}
+}
@@ -0,0 +1,130 @@
+package laurel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"unsafe"
+)
+
+/*
+ * Database Header Layout
+ *
+ * Page 0 holds the database header, the catalog's B-tree starts at page 1.
+ * Numbers are stored in the byte order of the machine that created the file,
+ * which the format version gives away when read on a machine of the other
+ * byte order.
+ */
+const (
+	DB_HEADER_PAGE_NUM              = 0
+	DB_HEADER_MAGIC                 = "laurel format 1\x00"
+	DB_HEADER_MAGIC_SIZE            = len(DB_HEADER_MAGIC)
+	DB_HEADER_MAGIC_OFFSET          = 0
+	DB_HEADER_VERSION_SIZE          = 4
+	DB_HEADER_VERSION_OFFSET        = DB_HEADER_MAGIC_OFFSET + DB_HEADER_MAGIC_SIZE
+	DB_HEADER_PAGE_SIZE_SIZE        = 4
+	DB_HEADER_PAGE_SIZE_OFFSET      = DB_HEADER_VERSION_OFFSET + DB_HEADER_VERSION_SIZE
+	DB_HEADER_PAGE_COUNT_SIZE       = 4
+	DB_HEADER_PAGE_COUNT_OFFSET     = DB_HEADER_PAGE_SIZE_OFFSET + DB_HEADER_PAGE_SIZE_SIZE
+	DB_HEADER_FREELIST_TRUNK_SIZE   = 4
+	DB_HEADER_FREELIST_TRUNK_OFFSET = DB_HEADER_PAGE_COUNT_OFFSET + DB_HEADER_PAGE_COUNT_SIZE
+	DB_HEADER_FREELIST_COUNT_SIZE   = 4
+	DB_HEADER_FREELIST_COUNT_OFFSET = DB_HEADER_FREELIST_TRUNK_OFFSET + DB_HEADER_FREELIST_TRUNK_SIZE
+	DB_HEADER_SCHEMA_COOKIE_SIZE    = 4
+	DB_HEADER_SCHEMA_COOKIE_OFFSET  = DB_HEADER_FREELIST_COUNT_OFFSET + DB_HEADER_FREELIST_COUNT_SIZE
+	DB_HEADER_CHANGE_COUNTER_SIZE   = 4
+	DB_HEADER_CHANGE_COUNTER_OFFSET = DB_HEADER_SCHEMA_COOKIE_OFFSET + DB_HEADER_SCHEMA_COOKIE_SIZE
+	DB_HEADER_SIZE                  = DB_HEADER_CHANGE_COUNTER_OFFSET + DB_HEADER_CHANGE_COUNTER_SIZE
+	DB_FORMAT_VERSION               = 4
+)
+
+var (
+	ErrNotADatabase      = errors.New("file is not a laurel database")
+	ErrByteOrder         = errors.New("database was created with the other byte order")
+	ErrUnsupportedFormat = errors.New("unsupported database format version")
+	ErrPageSize          = errors.New("unsupported page size")
+)
+
+// HeaderError is returned by DBopen for a file it refuses to open. Err is
+// one of ErrNotADatabase, ErrByteOrder, ErrUnsupportedFormat, ErrPageSize or
+// ErrCorruptSchema.
+type HeaderError struct {
+	Filename string
+	Err      error
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Filename, e.Err)
+}
+
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+func headerMagic(header []byte) []byte {
+	return header[DB_HEADER_MAGIC_OFFSET : DB_HEADER_MAGIC_OFFSET+DB_HEADER_MAGIC_SIZE]
+}
+
+func headerVersion(header []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&header[DB_HEADER_VERSION_OFFSET]))
+}
+
+func headerPageSize(header []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&header[DB_HEADER_PAGE_SIZE_OFFSET]))
+}
+
+// headerPageCount is the number of pages in the database file, header included.
+func headerPageCount(header []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&header[DB_HEADER_PAGE_COUNT_OFFSET]))
+}
+
+// headerFreelistTrunk is the first freelist trunk page, 0 when no page is free.
+func headerFreelistTrunk(header []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&header[DB_HEADER_FREELIST_TRUNK_OFFSET]))
+}
+
+// headerFreelistCount is the number of free pages, trunks included.
+func headerFreelistCount(header []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&header[DB_HEADER_FREELIST_COUNT_OFFSET]))
+}
+
+// headerSchemaCookie changes whenever the schema does.
+func headerSchemaCookie(header []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&header[DB_HEADER_SCHEMA_COOKIE_OFFSET]))
+}
+
+// headerChangeCounter is incremented by every commit that modifies the file.
+func headerChangeCounter(header []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&header[DB_HEADER_CHANGE_COUNTER_OFFSET]))
+}
+
+func initializeHeader(header []byte, pageSize uint32) {
+	copy(headerMagic(header), DB_HEADER_MAGIC)
+	*headerVersion(header) = DB_FORMAT_VERSION
+	*headerPageSize(header) = pageSize
+	*headerPageCount(header) = 0
+	*headerFreelistTrunk(header) = 0
+	*headerFreelistCount(header) = 0
+	*headerSchemaCookie(header) = 0
+	*headerChangeCounter(header) = 0
+}
+
+// validateHeader returns why a database with this header cannot be opened,
+// or nil if it can.
+func validateHeader(header []byte, pageSize uint32) error {
+	if !bytes.Equal(headerMagic(header), []byte(DB_HEADER_MAGIC)) {
+		return ErrNotADatabase
+	}
+	version := *headerVersion(header)
+	if version != DB_FORMAT_VERSION && bits.ReverseBytes32(version) == DB_FORMAT_VERSION {
+		return ErrByteOrder
+	}
+	if version != DB_FORMAT_VERSION {
+		return ErrUnsupportedFormat
+	}
+	if *headerPageSize(header) != pageSize {
+		return ErrPageSize
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package laurel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+)
+
+/*
+ * Rollback Journal Layout
+ *
+ * Before a page is first modified in a transaction its original image is
+ * appended to <db>-journal, and the journal is synced before any page of the
+ * database file is overwritten. Deleting the journal commits the transaction.
+ * A journal left behind by a crash is "hot": playing it back restores every
+ * page it holds and truncates the file to its size before the transaction.
+ *
+ * Header:  magic | page count before the transaction | page size | salt | checksum
+ * Records: page number | original page | checksum
+ */
+const (
+	JOURNAL_SUFFIX                 = "-journal"
+	JOURNAL_MAGIC                  = "laurel journal\x00\x00"
+	JOURNAL_MAGIC_SIZE             = len(JOURNAL_MAGIC)
+	JOURNAL_PAGE_COUNT_OFFSET      = JOURNAL_MAGIC_SIZE
+	JOURNAL_PAGE_SIZE_OFFSET       = JOURNAL_PAGE_COUNT_OFFSET + 4
+	JOURNAL_SALT_OFFSET            = JOURNAL_PAGE_SIZE_OFFSET + 4
+	JOURNAL_HEADER_CHECKSUM_OFFSET = JOURNAL_SALT_OFFSET + 4
+	JOURNAL_HEADER_SIZE            = JOURNAL_HEADER_CHECKSUM_OFFSET + 4
+	JOURNAL_RECORD_PAGE_NUM_SIZE   = 4
+	JOURNAL_RECORD_CHECKSUM_SIZE   = 4
+)
+
+func journalRecordSize(pageSize uint32) int {
+	return JOURNAL_RECORD_PAGE_NUM_SIZE + int(pageSize) + JOURNAL_RECORD_CHECKSUM_SIZE
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// journalChecksum ties a record to the journal it was written for, so stale
+// bytes from an earlier journal are never mistaken for a valid record.
+func journalChecksum(salt uint32, data []byte) uint32 {
+	var seed [4]byte
+	binary.LittleEndian.PutUint32(seed[:], salt)
+	return crc32.Update(crc32.Checksum(seed[:], castagnoliTable), castagnoliTable, data)
+}
+
+// openJournal starts a write transaction by creating a fresh journal.
+func (pager *Pager) openJournal() {
+	journal, err := os.OpenFile(pager.journalPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		fmt.Printf("Unable to open journal: %v\n", err)
+		os.Exit(1)
+	}
+	pager.journal_file = journal
+	pager.journalSalt = rand.Uint32()
+	pager.journalLength = int64(JOURNAL_HEADER_SIZE)
+	pager.journaledPages = make(map[uint32]bool)
+	pager.txnNumPages = pager.numPages
+
+	header := make([]byte, JOURNAL_HEADER_SIZE)
+	copy(header, JOURNAL_MAGIC)
+	binary.LittleEndian.PutUint32(header[JOURNAL_PAGE_COUNT_OFFSET:], pager.txnNumPages)
+	binary.LittleEndian.PutUint32(header[JOURNAL_PAGE_SIZE_OFFSET:], pager.pageSize)
+	binary.LittleEndian.PutUint32(header[JOURNAL_SALT_OFFSET:], pager.journalSalt)
+	binary.LittleEndian.PutUint32(header[JOURNAL_HEADER_CHECKSUM_OFFSET:],
+		crc32.Checksum(header[:JOURNAL_HEADER_CHECKSUM_OFFSET], castagnoliTable))
+	pager.journalWrite(header, 0)
+}
+
+// journalPage saves the original image of a page that is about to be
+// modified, unless it already is in the journal or did not exist when the
+// transaction started.
+func (pager *Pager) journalPage(page *cachedPage) {
+	if pager.journal_file == nil {
+		pager.openJournal()
+	}
+	if page.pageNum >= pager.txnNumPages || pager.journaledPages[page.pageNum] {
+		return
+	}
+
+	recordSize := journalRecordSize(pager.pageSize)
+	record := make([]byte, recordSize)
+	binary.LittleEndian.PutUint32(record, page.pageNum)
+	copy(record[JOURNAL_RECORD_PAGE_NUM_SIZE:], page.data)
+	checksum := journalChecksum(pager.journalSalt, record[:recordSize-JOURNAL_RECORD_CHECKSUM_SIZE])
+	binary.LittleEndian.PutUint32(record[recordSize-JOURNAL_RECORD_CHECKSUM_SIZE:], checksum)
+
+	pager.journalWrite(record, pager.journalLength)
+	pager.journalLength += int64(recordSize)
+	pager.journaledPages[page.pageNum] = true
+}
+
+func (pager *Pager) journalWrite(data []byte, offset int64) {
+	if pager.crashed {
+		return
+	}
+	if _, err := pager.journal_file.WriteAt(data, offset); err != nil {
+		fmt.Printf("Error writing journal: %v\n", err)
+		os.Exit(1)
+	}
+	pager.journalNeedsSync = true
+}
+
+// syncJournal makes the journal durable. It must happen before any page of
+// the database file is overwritten.
+func (pager *Pager) syncJournal() {
+	if pager.journal_file == nil || !pager.journalNeedsSync {
+		return
+	}
+	pager.syncFile(pager.journal_file)
+	pager.journalNeedsSync = false
+}
+
+// commit writes every modified page to the database file and deletes the
+// journal, which is the moment the transaction becomes permanent.
+func (pager *Pager) commit() {
+	if pager.modified {
+		header := pager.getWritablePage(DB_HEADER_PAGE_NUM)
+		*headerChangeCounter(header)++
+		pager.modified = false
+	}
+	if pager.journalMode == JOURNAL_MODE_WAL {
+		pager.walCommit()
+		return
+	}
+	if pager.journal_file == nil {
+		// Nothing was modified
+		return
+	}
+	for element := pager.lru.Front(); element != nil; element = element.Next() {
+		if page := element.Value.(*cachedPage); page.dirty {
+			pager.pager_flush(page.pageNum, pager.pageSize)
+		}
+	}
+	if !pager.crashed {
+		// Pages evicted before a rollback to a savepoint freed them
+		if err := pager.file_descriptor.Truncate(int64(pager.numPages) * int64(pager.pageSize)); err != nil {
+			fmt.Printf("Error truncating db file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	pager.syncFile(pager.file_descriptor)
+
+	pager.journal_file.Close()
+	pager.journal_file = nil
+	if !pager.crashed {
+		if err := os.Remove(pager.journalPath); err != nil {
+			fmt.Printf("Error deleting journal: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// abortTransaction ends the current transaction, undoing all of its changes.
+func (pager *Pager) abortTransaction() {
+	pager.inTransaction = false
+	pager.savepoints = nil
+	pager.rollback()
+}
+
+// rollback undoes every change since the last commit by playing the journal
+// back into the database file and forgetting the cached pages.
+func (pager *Pager) rollback() {
+	pager.modified = false
+	if pager.journalMode == JOURNAL_MODE_WAL {
+		pager.walRollback()
+		return
+	}
+	if pager.journal_file == nil || pager.crashed {
+		return
+	}
+	pager.journal_file.Close()
+	pager.journal_file = nil
+	if err := pager.playbackJournal(); err != nil {
+		fmt.Printf("Error rolling back journal: %v\n", err)
+		os.Exit(1)
+	}
+	pager.dropCache()
+	pager.numPages = pager.txnNumPages
+}
+
+// playbackJournal rolls back the transaction recorded in a hot journal, if
+// there is one, by copying the original pages back into the database file.
+func (pager *Pager) playbackJournal() error {
+	journal, err := os.ReadFile(pager.journalPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// A journal without a valid header was never synced, so no page of the
+	// database file was touched yet.
+	if len(journal) >= JOURNAL_HEADER_SIZE &&
+		bytes.Equal(journal[:JOURNAL_MAGIC_SIZE], []byte(JOURNAL_MAGIC)) &&
+		binary.LittleEndian.Uint32(journal[JOURNAL_HEADER_CHECKSUM_OFFSET:]) ==
+			crc32.Checksum(journal[:JOURNAL_HEADER_CHECKSUM_OFFSET], castagnoliTable) {
+		numPages := binary.LittleEndian.Uint32(journal[JOURNAL_PAGE_COUNT_OFFSET:])
+		pageSize := binary.LittleEndian.Uint32(journal[JOURNAL_PAGE_SIZE_OFFSET:])
+		salt := binary.LittleEndian.Uint32(journal[JOURNAL_SALT_OFFSET:])
+
+		// Records past a torn one were never synced either
+		recordSize := journalRecordSize(pageSize)
+		for offset := JOURNAL_HEADER_SIZE; offset+recordSize <= len(journal); offset += recordSize {
+			record := journal[offset : offset+recordSize]
+			body := record[:recordSize-JOURNAL_RECORD_CHECKSUM_SIZE]
+			if binary.LittleEndian.Uint32(record[len(body):]) != journalChecksum(salt, body) {
+				break
+			}
+			pageNum := binary.LittleEndian.Uint32(record)
+			if _, err := pager.file_descriptor.WriteAt(body[JOURNAL_RECORD_PAGE_NUM_SIZE:], int64(pageNum)*int64(pageSize)); err != nil {
+				return err
+			}
+		}
+		if err := pager.file_descriptor.Truncate(int64(numPages) * int64(pageSize)); err != nil {
+			return err
+		}
+		if err := pager.file_descriptor.Sync(); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(pager.journalPath)
+}
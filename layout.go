@@ -0,0 +1,64 @@
+package laurel
+
+/*
+ * Page Layout
+ *
+ * The page size is chosen when a database is created and recorded in its
+ * header. Everything about the layout that depends on it is worked out when
+ * the database is opened.
+ */
+const (
+	DEFAULT_PAGE_SIZE = 4096
+	MIN_PAGE_SIZE     = 512
+	MAX_PAGE_SIZE     = 65536
+)
+
+type pageLayout struct {
+	pageSize uint32
+	// usableSize leaves out the checksum at the end of every page
+	usableSize            uint32
+	leafNodeSpaceForCells uint32
+	// Cells store at most leafNodeMaxLocal bytes of their record, so that any
+	// two cells fit in a leaf together, which lets a full leaf always split in
+	// two. Records that are longer keep leafNodeMinLocal bytes or a little
+	// more in their cell and spill the rest onto overflow pages.
+	leafNodeMaxLocal     uint32
+	leafNodeMinLocal     uint32
+	overflowPageCapacity uint32
+	leafNodeMaxCells     uint32
+	// Non-root leaves filled below leafNodeMinFill bytes that also hold fewer
+	// than leafNodeMinCells cells borrow from or merge with a sibling
+	leafNodeMinFill        uint32
+	leafNodeMinCells       uint32
+	internalNodeMaxCells   uint32
+	freelistTrunkMaxLeaves uint32
+}
+
+// validPageSize reports whether pageSize is a power of two between
+// MIN_PAGE_SIZE and MAX_PAGE_SIZE.
+func validPageSize(pageSize uint32) bool {
+	return pageSize >= MIN_PAGE_SIZE && pageSize <= MAX_PAGE_SIZE && pageSize&(pageSize-1) == 0
+}
+
+func newPageLayout(pageSize uint32) pageLayout {
+	layout := pageLayout{pageSize: pageSize, usableSize: pageSize - PAGE_CHECKSUM_SIZE}
+	layout.leafNodeSpaceForCells = layout.usableSize - LEAF_NODE_HEADER_SIZE
+	maxCellSize := layout.leafNodeSpaceForCells/2 - LEAF_NODE_CELL_POINTER_SIZE
+	layout.leafNodeMaxLocal = maxCellSize - LEAF_NODE_MAX_CELL_OVERHEAD
+	layout.leafNodeMinLocal = layout.leafNodeSpaceForCells / 8
+	layout.overflowPageCapacity = layout.usableSize - OVERFLOW_HEADER_SIZE
+	layout.leafNodeMaxCells = layout.leafNodeSpaceForCells / (LEAF_NODE_MIN_CELL_SIZE + LEAF_NODE_CELL_POINTER_SIZE)
+	layout.leafNodeMinFill = layout.leafNodeSpaceForCells / 4
+	layout.leafNodeMinCells = layout.leafNodeMaxCells / 2
+	layout.internalNodeMaxCells = (layout.usableSize - INTERNAL_NODE_HEADER_SIZE) / INTERNAL_NODE_CELL_SIZE
+	layout.freelistTrunkMaxLeaves = (layout.usableSize - FREELIST_TRUNK_HEADER_SIZE) / FREELIST_TRUNK_LEAF_SIZE
+	return layout
+}
+
+// setLeafNodeMaxCells lowers the number of cells a leaf may hold, see
+// WithLeafNodeMaxCells.
+func (layout *pageLayout) setLeafNodeMaxCells(maxCells uint32) {
+	layout.leafNodeMaxCells = min(max(maxCells, 2), layout.leafNodeMaxCells)
+	// A leaf must not be left empty, even with a low maximum
+	layout.leafNodeMinCells = max(layout.leafNodeMaxCells/2, 1)
+}
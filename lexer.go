@@ -0,0 +1,283 @@
+package laurel
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type TokenType int
+
+const (
+	TOKEN_EOF TokenType = iota
+	TOKEN_KEYWORD
+	TOKEN_IDENTIFIER
+	TOKEN_STRING
+	TOKEN_NUMBER
+	TOKEN_BLOB
+	TOKEN_OPERATOR
+)
+
+// keywords are matched case-insensitively, and stored in lower case in the
+// text of their token. They cannot be used as bare identifiers.
+var keywords = map[string]bool{
+	"add":       true,
+	"alter":     true,
+	"and":       true,
+	"as":        true,
+	"asc":       true,
+	"begin":     true,
+	"between":   true,
+	"by":        true,
+	"column":    true,
+	"commit":    true,
+	"create":    true,
+	"default":   true,
+	"delete":    true,
+	"desc":      true,
+	"drop":      true,
+	"from":      true,
+	"in":        true,
+	"insert":    true,
+	"into":      true,
+	"is":        true,
+	"key":       true,
+	"limit":     true,
+	"not":       true,
+	"null":      true,
+	"offset":    true,
+	"or":        true,
+	"order":     true,
+	"primary":   true,
+	"release":   true,
+	"rename":    true,
+	"rollback":  true,
+	"savepoint": true,
+	"select":    true,
+	"set":       true,
+	"table":     true,
+	"to":        true,
+	"update":    true,
+	"values":    true,
+	"where":     true,
+}
+
+// operators lists the multi-character operators before their prefixes. "."
+// and "@" are not part of any expression, but the short form of insert reads
+// them as part of a bare word.
+var operators = []string{"<=", ">=", "<>", "!=", "||", "=", "<", ">", ",", ";", "(", ")", "*", "+", "-", "/", "%", ".", "@"}
+
+// Token is a lexeme of a statement. The text of a string or quoted
+// identifier has its quotes removed and its doubled quotes unescaped, the
+// text of a blob is its bytes.
+type Token struct {
+	ttype  TokenType
+	text   string
+	line   int // 1-based
+	column int // 1-based, in bytes
+	// offset and end are where the token starts and ends in the input, in
+	// bytes, comments and whitespace around it left out
+	offset int
+	end    int
+}
+
+func (token Token) String() string {
+	switch token.ttype {
+	case TOKEN_EOF:
+		return "end of input"
+	case TOKEN_STRING:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(token.text, "'", "''"))
+	case TOKEN_BLOB:
+		return fmt.Sprintf("x'%x'", token.text)
+	default:
+		return fmt.Sprintf("%q", token.text)
+	}
+}
+
+// SyntaxError is why a statement could not be parsed, located at the token
+// that gave it away.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+func syntaxErrorf(token Token, format string, args ...any) *SyntaxError {
+	return &SyntaxError{Line: token.line, Column: token.column, Msg: fmt.Sprintf(format, args...)}
+}
+
+type lexer struct {
+	input  string
+	pos    int
+	line   int
+	column int
+}
+
+// tokenize splits a statement into tokens, ending with a TOKEN_EOF.
+// Whitespace, "-- line" and "/* block */" comments separate tokens.
+func tokenize(input string) ([]Token, error) {
+	lex := &lexer{input: input, line: 1, column: 1}
+	tokens := make([]Token, 0)
+	for {
+		if err := lex.skipSpaceAndComments(); err != nil {
+			return nil, err
+		}
+		token, err := lex.next()
+		if err != nil {
+			return nil, err
+		}
+		token.end = lex.pos
+		tokens = append(tokens, token)
+		if token.ttype == TOKEN_EOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (lex *lexer) peekByte(offset int) byte {
+	if lex.pos+offset >= len(lex.input) {
+		return 0
+	}
+	return lex.input[lex.pos+offset]
+}
+
+func (lex *lexer) advance(n int) {
+	for ; n > 0; n-- {
+		if lex.input[lex.pos] == '\n' {
+			lex.line++
+			lex.column = 1
+		} else {
+			lex.column++
+		}
+		lex.pos++
+	}
+}
+
+func (lex *lexer) skipSpaceAndComments() error {
+	for lex.pos < len(lex.input) {
+		switch c := lex.peekByte(0); {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			lex.advance(1)
+		case c == '-' && lex.peekByte(1) == '-':
+			end := strings.IndexByte(lex.input[lex.pos:], '\n')
+			if end < 0 {
+				end = len(lex.input) - lex.pos
+			}
+			lex.advance(end)
+		case c == '/' && lex.peekByte(1) == '*':
+			start := Token{line: lex.line, column: lex.column}
+			end := strings.Index(lex.input[lex.pos+2:], "*/")
+			if end < 0 {
+				return syntaxErrorf(start, "unterminated comment")
+			}
+			lex.advance(end + 4)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isWordByte reports whether c can continue an identifier.
+func isWordByte(c byte) bool {
+	return isLetter(c) || isDigit(c)
+}
+
+func (lex *lexer) next() (Token, error) {
+	token := Token{line: lex.line, column: lex.column, offset: lex.pos}
+	start := lex.pos
+	c := lex.peekByte(0)
+	switch {
+	case lex.pos >= len(lex.input):
+		token.ttype = TOKEN_EOF
+		return token, nil
+	case (c == 'x' || c == 'X') && lex.peekByte(1) == '\'':
+		lex.advance(1)
+		text, ok := lex.quoted('\'')
+		if !ok {
+			return token, syntaxErrorf(token, "unterminated blob literal")
+		}
+		blob, err := hex.DecodeString(text)
+		if err != nil {
+			return token, syntaxErrorf(token, "malformed blob literal x'%s'", text)
+		}
+		token.ttype, token.text = TOKEN_BLOB, string(blob)
+		return token, nil
+	case isLetter(c):
+		for lex.pos < len(lex.input) && isWordByte(lex.peekByte(0)) {
+			lex.advance(1)
+		}
+		token.ttype, token.text = TOKEN_IDENTIFIER, lex.input[start:lex.pos]
+		if lower := strings.ToLower(token.text); keywords[lower] {
+			token.ttype, token.text = TOKEN_KEYWORD, lower
+		}
+		return token, nil
+	case isDigit(c):
+		for isDigit(lex.peekByte(0)) {
+			lex.advance(1)
+		}
+		if lex.peekByte(0) == '.' && isDigit(lex.peekByte(1)) {
+			lex.advance(1)
+			for isDigit(lex.peekByte(0)) {
+				lex.advance(1)
+			}
+		}
+		if isLetter(lex.peekByte(0)) {
+			return token, syntaxErrorf(token, "malformed number %q", lex.input[start:lex.pos+1])
+		}
+		token.ttype, token.text = TOKEN_NUMBER, lex.input[start:lex.pos]
+		return token, nil
+	case c == '\'' || c == '"':
+		text, ok := lex.quoted(c)
+		if !ok {
+			return token, syntaxErrorf(token, "unterminated quoted string")
+		}
+		token.ttype, token.text = TOKEN_STRING, text
+		if c == '"' {
+			token.ttype = TOKEN_IDENTIFIER
+		}
+		return token, nil
+	}
+
+	for _, operator := range operators {
+		if strings.HasPrefix(lex.input[lex.pos:], operator) {
+			lex.advance(len(operator))
+			token.ttype, token.text = TOKEN_OPERATOR, operator
+			return token, nil
+		}
+	}
+	return token, syntaxErrorf(token, "unexpected character %q", c)
+}
+
+// quoted reads a string enclosed in quote, in which a doubled quote stands
+// for the quote itself.
+func (lex *lexer) quoted(quote byte) (string, bool) {
+	var text strings.Builder
+	lex.advance(1)
+	for lex.pos < len(lex.input) {
+		c := lex.peekByte(0)
+		if c == quote && lex.peekByte(1) == quote {
+			text.WriteByte(quote)
+			lex.advance(2)
+			continue
+		}
+		lex.advance(1)
+		if c == quote {
+			return text.String(), true
+		}
+		text.WriteByte(c)
+	}
+	return "", false
+}
@@ -15,6 +15,17 @@ type Options struct {
 	IsTestCmd bool
 	InputCmd  <-chan string
 	ResMsg    chan string
+
+	CacheSize            uint32
+	SortMemory           uint32
+	InternalNodeMaxCells uint32
+	LeafNodeMaxCells     uint32
+	CrashAtWrite         uint32
+	JournalMode          JournalMode
+	WalAutoCheckpoint    uint32
+
+	SkipChecksumVerification bool
+	PageSize                 uint32
 }
 
 // WithOptions accepts the whole options config.
@@ -47,3 +58,80 @@ func WithResMsg(ResMsg chan string) Option {
 		opts.ResMsg = ResMsg
 	}
 }
+
+// WithInternalNodeMaxCells lowers the number of keys an internal node may
+// hold before it splits. It is meant for tests that want to exercise
+// internal node splits without inserting thousands of rows.
+func WithInternalNodeMaxCells(InternalNodeMaxCells uint32) Option {
+	return func(opts *Options) {
+		opts.InternalNodeMaxCells = InternalNodeMaxCells
+	}
+}
+
+// WithLeafNodeMaxCells lowers the number of cells a leaf may hold before it
+// splits, however short they are. Like WithInternalNodeMaxCells it is meant
+// for tests, which can then build deep trees out of few rows.
+func WithLeafNodeMaxCells(LeafNodeMaxCells uint32) Option {
+	return func(opts *Options) {
+		opts.LeafNodeMaxCells = LeafNodeMaxCells
+	}
+}
+
+// WithCacheSize sets how many pages the pager keeps in memory, defaults to
+// DEFAULT_CACHE_SIZE and is never below MIN_CACHE_SIZE.
+func WithCacheSize(CacheSize uint32) Option {
+	return func(opts *Options) {
+		opts.CacheSize = CacheSize
+	}
+}
+
+// WithSortMemory sets how many bytes of rows a sort holds in memory before
+// it spills them to a temporary file, defaults to DEFAULT_SORT_MEMORY.
+func WithSortMemory(SortMemory uint32) Option {
+	return func(opts *Options) {
+		opts.SortMemory = SortMemory
+	}
+}
+
+// WithCrashAtWrite simulates a power loss for tests: the CrashAtWrite-th page
+// write to the database file or WAL, and any disk access after it, is silently
+// dropped, leaving the files as a crash at that moment would.
+func WithCrashAtWrite(CrashAtWrite uint32) Option {
+	return func(opts *Options) {
+		opts.CrashAtWrite = CrashAtWrite
+	}
+}
+
+// WithJournalMode chooses how commits are made atomic, by a rollback journal
+// (the default) or by a write-ahead log.
+func WithJournalMode(JournalMode JournalMode) Option {
+	return func(opts *Options) {
+		opts.JournalMode = JournalMode
+	}
+}
+
+// WithWalAutoCheckpoint sets after how many WAL frames a commit checkpoints
+// the WAL into the database file, defaults to DEFAULT_WAL_AUTO_CHECKPOINT.
+func WithWalAutoCheckpoint(WalAutoCheckpoint uint32) Option {
+	return func(opts *Options) {
+		opts.WalAutoCheckpoint = WalAutoCheckpoint
+	}
+}
+
+// WithSkipChecksumVerification trusts pages read from disk without checking
+// their checksum, which is faster but reads corrupt pages as if they were
+// fine. Checksums are written either way.
+func WithSkipChecksumVerification(SkipChecksumVerification bool) Option {
+	return func(opts *Options) {
+		opts.SkipChecksumVerification = SkipChecksumVerification
+	}
+}
+
+// WithPageSize sets the page size of a new database, a power of two between
+// MIN_PAGE_SIZE and MAX_PAGE_SIZE that defaults to DEFAULT_PAGE_SIZE. An
+// existing database keeps the page size it was created with.
+func WithPageSize(PageSize uint32) Option {
+	return func(opts *Options) {
+		opts.PageSize = PageSize
+	}
+}
@@ -0,0 +1,130 @@
+package laurel
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+/*
+ * Overflow Page Layout
+ *
+ * A record too large for a leaf keeps its head in its cell, and the rest is
+ * spilled onto a chain of overflow pages that the cell points at. Each
+ * overflow page points at the next, the last one at 0, and is filled from
+ * the end of its header up to its checksum.
+ */
+const (
+	OVERFLOW_NEXT_SIZE   = 4
+	OVERFLOW_NEXT_OFFSET = COMMON_NODE_HEADER_SIZE
+	OVERFLOW_HEADER_SIZE = COMMON_NODE_HEADER_SIZE + OVERFLOW_NEXT_SIZE
+)
+
+func overflowNext(page []byte) *uint32 {
+	return (*uint32)(unsafe.Pointer(&page[OVERFLOW_NEXT_OFFSET]))
+}
+
+// overflowData returns the part of an overflow page that holds record bytes.
+func overflowData(page []byte) []byte {
+	return page[OVERFLOW_HEADER_SIZE : len(page)-PAGE_CHECKSUM_SIZE]
+}
+
+func initializeOverflowPage(page []byte) {
+	setNodeType(page, NODE_OVERFLOW)
+	setNodeRoot(page, false)
+	*nodeParent(page) = 0
+	*overflowNext(page) = 0
+}
+
+// leafNodeLocalSize returns how much of a record of recordLength bytes is
+// stored in its cell. Records that do not fit keep at least leafNodeMinLocal
+// bytes, and as much more as lets the last overflow page be full.
+func (pager *Pager) leafNodeLocalSize(recordLength uint64) uint32 {
+	if recordLength <= uint64(pager.leafNodeMaxLocal) {
+		return uint32(recordLength)
+	}
+	minLocal := uint64(pager.leafNodeMinLocal)
+	local := minLocal + (recordLength-minLocal)%uint64(pager.overflowPageCapacity)
+	if local > uint64(pager.leafNodeMaxLocal) {
+		local = minLocal
+	}
+	return uint32(local)
+}
+
+// leafNodeCellSize returns the size of the cell of a record of recordLength
+// bytes, without spilling it.
+func (pager *Pager) leafNodeCellSize(recordLength uint64) uint32 {
+	local := pager.leafNodeLocalSize(recordLength)
+	size := LEAF_NODE_KEY_SIZE + uint32(len(binary.AppendUvarint(nil, recordLength))) +
+		uint32(len(binary.AppendUvarint(nil, uint64(local)))) + local
+	if uint64(local) < recordLength {
+		size += LEAF_NODE_OVERFLOW_PAGE_SIZE
+	}
+	return size
+}
+
+// makeLeafNodeCell returns the cell storing record under key, spilling the
+// part of the record that does not fit onto new overflow pages.
+func (pager *Pager) makeLeafNodeCell(key uint32, record []byte) []byte {
+	local := pager.leafNodeLocalSize(uint64(len(record)))
+	cell := binary.NativeEndian.AppendUint32(nil, key)
+	cell = binary.AppendUvarint(cell, uint64(len(record)))
+	cell = binary.AppendUvarint(cell, uint64(local))
+	cell = append(cell, record[:local]...)
+	if int(local) == len(record) {
+		return cell
+	}
+
+	// Write the chain from its end, so that each page knows its next
+	rest := record[local:]
+	nextPageNum := uint32(0)
+	for end := len(rest); end > 0; {
+		start := (end - 1) / int(pager.overflowPageCapacity) * int(pager.overflowPageCapacity)
+		pageNum := pager.getUnusedPageNum()
+		page := pager.getWritablePage(pageNum)
+		initializeOverflowPage(page)
+		*overflowNext(page) = nextPageNum
+		clear(overflowData(page))
+		copy(overflowData(page), rest[start:end])
+		nextPageNum, end = pageNum, start
+	}
+	return binary.NativeEndian.AppendUint32(cell, nextPageNum)
+}
+
+// leafNodeRecord returns the record stored in the cell at cellNum of the
+// leaf at leafPageNum, read back from its overflow pages if it spilled. It
+// panics with *ErrCorruptPage if the chain ends early or leads to a page that
+// is not an overflow page, blaming the leaf or overflow page pointing there.
+func (pager *Pager) leafNodeRecord(leafPageNum uint32, cellNum uint32) []byte {
+	node := pager.getPage(leafPageNum)
+	recordLength, local, overflowPageNum, _ := parseLeafNodeCell(leafNodeCell(node, cellNum))
+	if overflowPageNum == 0 {
+		return local
+	}
+
+	record := make([]byte, 0, recordLength)
+	record = append(record, local...)
+	referrer := leafPageNum
+	for pageNum := overflowPageNum; uint64(len(record)) < recordLength; {
+		if pageNum == 0 || pageNum >= pager.numPages {
+			panic(&ErrCorruptPage{PageNum: referrer})
+		}
+		page := pager.getPage(pageNum)
+		if getNodeType(page) != NODE_OVERFLOW {
+			panic(&ErrCorruptPage{PageNum: referrer})
+		}
+		data := overflowData(page)
+		record = append(record, data[:min(uint64(len(data)), recordLength-uint64(len(record)))]...)
+		referrer, pageNum = pageNum, *overflowNext(page)
+	}
+	return record
+}
+
+// freeOverflow puts the overflow pages of a cell on the freelist.
+func (pager *Pager) freeOverflow(cell []byte) {
+	_, _, pageNum, _ := parseLeafNodeCell(cell)
+	for pageNum != 0 {
+		nextPageNum := *overflowNext(pager.getPage(pageNum))
+		pager.freePage(pageNum)
+		pageNum = nextPageNum
+	}
+}
@@ -1,66 +1,242 @@
 package laurel
 
 import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 )
 
+const (
+	DEFAULT_CACHE_SIZE = 2000
+	/* Enough pages for any single B-tree operation to keep its working set cached */
+	MIN_CACHE_SIZE = 16
+)
+
 type Pager struct {
 	file_descriptor *os.File
 	file_length     uint32
-	pages           [TABLE_MAX_PAGES][PAGE_SIZE]byte
 	numPages        uint32
+	pageLayout
+
+	// Cached pages, least recently used at the back of lru
+	cacheSize uint32
+	cache     map[uint32]*list.Element
+	lru       *list.List
+
+	verifyChecksums bool
+
+	// Bytes of rows a sort holds in memory before spilling them to disk
+	sortMemory uint32
+
+	// Set between begin and commit or rollback, otherwise every statement
+	// is committed on its own.
+	inTransaction bool
+	modified      bool         // a page was modified since the last commit
+	savepoints    []*savepoint // innermost last
+
+	// Rollback journal of the current write transaction, nil when no page
+	// has been modified since the last commit.
+	journalPath      string
+	journal_file     *os.File
+	journalSalt      uint32
+	journalLength    int64
+	journalNeedsSync bool
+	journaledPages   map[uint32]bool
+	txnNumPages      uint32 // numPages when the transaction started
+
+	// Write-ahead log used instead of the rollback journal in WAL mode.
+	// walIndex maps a page to the offset of its newest committed frame.
+	journalMode       JournalMode
+	walPath           string
+	wal_file          *os.File
+	walSalt           uint32
+	walChecksum       uint32 // checksum of the last frame, chained into the next
+	walLength         int64
+	walIndex          map[uint32]int64
+	walFrames         uint32 // frames written since the last checkpoint
+	walNumPages       uint32 // numPages as of the last commit frame
+	walAutoCheckpoint uint32
+	// Frames of the current transaction written to make room in the cache,
+	// and where the WAL ended before the first of them.
+	walPending         map[uint32]int64
+	walPendingLength   int64
+	walPendingChecksum uint32
+
+	// Simulated power loss for tests, see WithCrashAtWrite
+	crashAtWrite uint32
+	diskWrites   uint32
+	crashed      bool
 }
 
-func (pager *Pager) getPage(page_num uint32) *[PAGE_SIZE]byte {
-	if page_num > TABLE_MAX_PAGES {
-		fmt.Printf("Tried to fetch page number out of bounds. %d > %d\n", page_num,
-			TABLE_MAX_PAGES)
-		os.Exit(1)
+type cachedPage struct {
+	pageNum  uint32
+	data     []byte
+	dirty    bool
+	pinCount uint32
+}
+
+// getPage returns the cached copy of a page for reading, loading it from
+// disk on a miss. The returned pointer stays valid while the page is pinned,
+// and otherwise until fewer than cacheSize other pages have been fetched,
+// after which the page may be evicted and a later getPage hands out a fresh
+// copy.
+func (pager *Pager) getPage(page_num uint32) []byte {
+	return pager.cachedPage(page_num).data
+}
+
+// getWritablePage is getPage for callers that are about to modify the page.
+// Only pages fetched this way are written back to disk.
+func (pager *Pager) getWritablePage(page_num uint32) []byte {
+	page := pager.cachedPage(page_num)
+	pager.savepointPage(page)
+	pager.markDirty(page)
+	return page.data
+}
+
+func (pager *Pager) markDirty(page *cachedPage) {
+	if pager.journalMode == JOURNAL_MODE_ROLLBACK {
+		pager.journalPage(page)
+	}
+	page.dirty = true
+	pager.modified = true
+}
+
+// close releases the files of a pager that never modified a page.
+func (pager *Pager) close() {
+	pager.file_descriptor.Close()
+	if pager.wal_file != nil {
+		pager.wal_file.Close()
 	}
+}
 
-	if IsZeroPage(pager.pages[page_num]) {
-		page := make([]byte, PAGE_SIZE)
-		num_pages := pager.file_length / PAGE_SIZE
+func (pager *Pager) cachedPage(page_num uint32) *cachedPage {
+	if element, ok := pager.cache[page_num]; ok {
+		pager.lru.MoveToFront(element)
+		return element.Value.(*cachedPage)
+	}
 
-		if pager.file_length%PAGE_SIZE != 0 {
-			num_pages += 1
-		}
+	page := &cachedPage{pageNum: page_num, data: make([]byte, pager.pageSize)}
+	if pager.readPage(page_num, page.data) && pager.verifyChecksums {
+		verifyPageChecksum(page_num, page.data)
+	}
 
-		if page_num <= uint32(num_pages) {
-			pager.file_descriptor.Seek(int64(page_num)*PAGE_SIZE, 0)
-			bytes_read, err := pager.file_descriptor.Read(page)
-			if bytes_read == -1 {
-				fmt.Printf("Error reading file: %v\n", err)
-				os.Exit(1)
-			}
-		}
-		CopyPage(&pager.pages[page_num], page[:])
-		if page_num >= pager.numPages {
-			pager.numPages = page_num + 1
+	pager.cache[page_num] = pager.lru.PushFront(page)
+	if page_num >= pager.numPages {
+		pager.numPages = page_num + 1
+	}
+	pager.evictPages()
+	return page
+}
+
+// readPage reads a page from the WAL or the database file and reports
+// whether it was there at all. A page past the end of the file is new and
+// comes back zeroed.
+func (pager *Pager) readPage(page_num uint32, data []byte) bool {
+	if pager.walReadPage(page_num, data) {
+		return true
+	}
+	bytes_read, err := pager.file_descriptor.ReadAt(data, int64(page_num)*int64(pager.pageSize))
+	if err != nil && err != io.EOF {
+		fmt.Printf("Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+	clear(data[bytes_read:])
+	return bytes_read > 0
+}
+
+// evictPages drops least recently used pages that are not pinned until the
+// cache fits in cacheSize again, writing modified ones back to disk. In WAL
+// mode the database file must not change before a checkpoint, so modified
+// pages are appended to the WAL as frames that only the commit frame makes
+// visible.
+func (pager *Pager) evictPages() {
+	// The front page was just fetched and is about to be used
+	element := pager.lru.Back()
+	for uint32(pager.lru.Len()) > pager.cacheSize && element != pager.lru.Front() {
+		page := element.Value.(*cachedPage)
+		prev := element.Prev()
+		if page.pinCount == 0 && pager.writeBack(page) {
+			pager.lru.Remove(element)
+			delete(pager.cache, page.pageNum)
 		}
+		element = prev
 	}
+}
 
-	return &pager.pages[page_num]
+// writeBack saves a page that is about to be evicted if it was modified, and
+// reports whether it may be evicted.
+func (pager *Pager) writeBack(page *cachedPage) bool {
+	if !page.dirty {
+		return true
+	}
+	if pager.journalMode == JOURNAL_MODE_WAL {
+		return pager.walSpill(page)
+	}
+	pager.pager_flush(page.pageNum, pager.pageSize)
+	return true
+}
+
+// pinPage keeps a page cached, and pointers to it valid, until the matching
+// unpinPage.
+func (pager *Pager) pinPage(page_num uint32) {
+	pager.cachedPage(page_num).pinCount++
+}
+
+func (pager *Pager) unpinPage(page_num uint32) {
+	if element, ok := pager.cache[page_num]; ok {
+		element.Value.(*cachedPage).pinCount--
+	}
+}
+
+// dropCache forgets every cached page without writing any of them back.
+func (pager *Pager) dropCache() {
+	clear(pager.cache)
+	pager.lru.Init()
 }
 
 func (pager *Pager) pager_flush(page_num uint32, size uint32) {
-	if IsZeroPage(pager.pages[page_num]) {
-		fmt.Printf("Tried to flush null page\n")
+	element, ok := pager.cache[page_num]
+	if !ok {
+		fmt.Printf("Tried to flush uncached page\n")
 		os.Exit(1)
 	}
+	page := element.Value.(*cachedPage)
 
-	pager.file_descriptor.Seek(int64(page_num)*PAGE_SIZE, 0)
+	pager.syncJournal()
+	if !pager.countDiskWrite() {
+		return
+	}
 
-	bytes_written, err := pager.file_descriptor.Write(pager.pages[page_num][:size])
+	setPageChecksum(page.data)
+	bytes_written, err := pager.file_descriptor.WriteAt(page.data[:size], int64(page_num)*int64(pager.pageSize))
 	if err != nil || bytes_written == -1 {
 		fmt.Printf("Error writing: %v\n", err)
 		os.Exit(1)
 	}
+	page.dirty = false
 }
 
-func (pager *Pager) getUnusedPageNum() uint32 {
-	return pager.numPages
+// countDiskWrite accounts for a page about to be written to disk and
+// reports whether the write should happen, see WithCrashAtWrite.
+func (pager *Pager) countDiskWrite() bool {
+	if pager.crashAtWrite != 0 {
+		pager.diskWrites++
+		pager.crashed = pager.crashed || pager.diskWrites >= pager.crashAtWrite
+	}
+	return !pager.crashed
+}
+
+func (pager *Pager) syncFile(file *os.File) {
+	if pager.crashed {
+		return
+	}
+	if err := file.Sync(); err != nil {
+		fmt.Printf("Error syncing %s: %v\n", file.Name(), err)
+		os.Exit(1)
+	}
 }
 
 func (pager *Pager) printTree(pageNum uint32, indentationLevel uint32) {
@@ -92,25 +268,83 @@ func (pager *Pager) printTree(pageNum uint32, indentationLevel uint32) {
 	}
 }
 
-func pager_open(filename string) (*Pager, error) {
+// storedPageSize returns the page size recorded in the database header, or
+// in the WAL for a database that has not been checkpointed yet, and 0 when
+// there is none. A header that cannot be trusted is left for DBopen to
+// refuse.
+func (pager *Pager) storedPageSize() uint32 {
+	header := make([]byte, DB_HEADER_SIZE)
+	if _, err := pager.file_descriptor.ReadAt(header, 0); err == nil &&
+		bytes.Equal(headerMagic(header), []byte(DB_HEADER_MAGIC)) &&
+		*headerVersion(header) == DB_FORMAT_VERSION &&
+		validPageSize(*headerPageSize(header)) {
+		return *headerPageSize(header)
+	}
+
+	wal, err := os.Open(pager.walPath)
+	if err != nil {
+		return 0
+	}
+	defer wal.Close()
+	walHeader := make([]byte, WAL_HEADER_SIZE)
+	if _, err := wal.ReadAt(walHeader, 0); err == nil && validWalHeader(walHeader) {
+		return binary.LittleEndian.Uint32(walHeader[WAL_PAGE_SIZE_OFFSET:])
+	}
+	return 0
+}
+
+func pager_open(filename string, opts *Options) (*Pager, error) {
 	fd, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		fmt.Printf("Unable to open file\n")
 		return nil, err
 	}
 
+	pager := &Pager{
+		file_descriptor:   fd,
+		journalPath:       filename + JOURNAL_SUFFIX,
+		journalMode:       opts.JournalMode,
+		walPath:           filename + WAL_SUFFIX,
+		walAutoCheckpoint: opts.WalAutoCheckpoint,
+		crashAtWrite:      opts.CrashAtWrite,
+		verifyChecksums:   !opts.SkipChecksumVerification,
+		cacheSize:         max(opts.CacheSize, MIN_CACHE_SIZE),
+		sortMemory:        opts.SortMemory,
+		cache:             make(map[uint32]*list.Element),
+		lru:               list.New(),
+	}
+	if err := pager.playbackJournal(); err != nil {
+		fmt.Printf("Unable to roll back journal\n")
+		return nil, err
+	}
+
 	fileInfo, err := fd.Stat()
 	if err != nil {
 		fmt.Printf("Unable to get file stats\n")
 		return nil, err
 	}
 
+	pageSize := pager.storedPageSize()
+	if pageSize == 0 {
+		pageSize = opts.PageSize
+	}
+	pager.pageLayout = newPageLayout(pageSize)
+
 	fileLength := fileInfo.Size()
+	pager.file_length = uint32(fileLength)
+	pager.numPages = uint32(fileLength / int64(pageSize))
 
-	pager := &Pager{
-		file_descriptor: fd,
-		file_length:     uint32(fileLength),
-		numPages:        uint32(fileLength) / PAGE_SIZE,
+	if err := pager.recoverWal(); err != nil {
+		fmt.Printf("Unable to recover WAL\n")
+		return nil, err
+	}
+	if len(pager.walIndex) > 0 {
+		pager.numPages = pager.walNumPages
+	}
+	pager.txnNumPages = pager.numPages
+	if pager.journalMode == JOURNAL_MODE_ROLLBACK {
+		// Fold a WAL left by an earlier session in WAL mode into the file
+		pager.closeWal()
 	}
 
 	return pager, nil
@@ -3,9 +3,13 @@ package laurel
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 type PrepareResult int
@@ -16,6 +20,8 @@ const (
 	PREPARE_STRING_TOO_LONG
 	PREPARE_SYNTAX_ERROR
 	PREPARE_UNRECOGNIZED_STATEMENT
+	PREPARE_EMPTY_STATEMENT
+	PREPARE_ERROR
 )
 
 type MetaCommandResult int
@@ -32,6 +38,13 @@ const (
 	EXECUTE_SUCCESS ExecuteResult = iota
 	EXECUTE_DUPLICATE_KEY
 	EXECUTE_TABLE_FULL
+	EXECUTE_ROW_NOT_FOUND
+	EXECUTE_TRANSACTION_ACTIVE
+	EXECUTE_NO_TRANSACTION
+	EXECUTE_NO_SUCH_SAVEPOINT
+	EXECUTE_CORRUPT_PAGE
+	EXECUTE_TABLE_EXISTS
+	EXECUTE_IO_ERROR
 )
 
 type InputBuffer struct {
@@ -50,42 +63,441 @@ func (i *InputBuffer) clear() {
 	i.input_length = 0
 }
 
-func prepare_insert(input_buffer *InputBuffer, statement *Statement) PrepareResult {
-	statement.stype = STATEMENT_INSERT
+// syntaxError fails the statement with a syntax error, kept in statement.err
+// for its position.
+func (statement *Statement) syntaxError(err error) PrepareResult {
+	statement.err = err
+	return PREPARE_SYNTAX_ERROR
+}
+
+// prepareError fails the statement with an error that is not about syntax.
+func (statement *Statement) prepareError(err error) PrepareResult {
+	statement.err = err
+	return PREPARE_ERROR
+}
 
-	tokens := bytes.Split(input_buffer.buffer, []byte(" "))
-	if len(tokens) < 4 {
-		return PREPARE_SYNTAX_ERROR
+// integerValue returns the value of an integer literal.
+func integerValue(expr Expr) (int64, error) {
+	number, ok := expr.(*NumberLiteral)
+	if !ok {
+		return 0, syntaxErrorf(expr.position(), "expected an integer, got %v", expr.position())
+	}
+	value, err := strconv.ParseInt(number.text, 10, 64)
+	if err != nil {
+		return 0, syntaxErrorf(number.pos, "expected an integer, got %s", number.text)
 	}
+	return value, nil
+}
 
-	id, err := strconv.Atoi(string(tokens[1]))
-	if err != nil || id < 0 {
+// prepare_id reads the id of a row from an integer literal.
+func prepare_id(expr Expr, statement *Statement, id *int64) PrepareResult {
+	value, err := integerValue(expr)
+	if err != nil {
+		return statement.syntaxError(err)
+	}
+	if value < 0 {
 		return PREPARE_NEGATIVE_ID
 	}
-	username := tokens[2]
-	email := tokens[3]
+	*id = value
+	return PREPARE_SUCCESS
+}
 
-	if len(username) > COLUMN_USERNAME_SIZE || len(email) > COLUMN_EMAIL_SIZE {
+// prepare_count reads the count of a limit or offset clause from an integer
+// literal, leaving count as it is if there is no clause.
+func prepare_count(expr Expr, statement *Statement, count *int64) PrepareResult {
+	if expr == nil {
+		return PREPARE_SUCCESS
+	}
+	value, err := integerValue(expr)
+	if err != nil {
+		return statement.syntaxError(err)
+	}
+	*count = value
+	return PREPARE_SUCCESS
+}
+
+// prepare_table finds the table a statement names, or the default table if
+// it names none. Only select may use the catalog.
+func prepare_table(name *Token, statement *Statement, catalog *Table) PrepareResult {
+	tableName := DEFAULT_TABLE
+	if name != nil {
+		tableName = name.text
+	}
+	statement.table = catalog.findTable(tableName)
+	if statement.table == nil {
+		return statement.prepareError(fmt.Errorf("no such table: %s", tableName))
+	}
+	if statement.table == catalog && statement.stype != STATEMENT_SELECT {
+		return statement.prepareError(fmt.Errorf("table %s may not be modified", CATALOG_NAME))
+	}
+	return PREPARE_SUCCESS
+}
+
+// prepare_value converts a literal to a value of a column.
+func prepare_value(expr Expr, column *Column, statement *Statement, value *any) PrepareResult {
+	literal, err := literalValue(expr)
+	if err != nil {
+		return statement.syntaxError(err)
+	}
+	converted, err := convertValue(literal, column)
+	if err != nil {
+		return statement.prepareError(err)
+	}
+	if column.maxLength > 0 && valueLength(converted) > column.maxLength {
 		return PREPARE_STRING_TOO_LONG
 	}
+	*value = converted
+	return PREPARE_SUCCESS
+}
+
+func prepare_create_table(stmt *CreateTableStmt, statement *Statement) PrepareResult {
+	statement.stype = STATEMENT_CREATE_TABLE
+
+	schema, err := newSchema(stmt)
+	if err != nil {
+		return statement.syntaxError(err)
+	}
+	statement.schema = schema
+	return PREPARE_SUCCESS
+}
 
-	statement.rowToInsert.id = uint32(id)
-	copy(statement.rowToInsert.username[:], username)
-	copy(statement.rowToInsert.email[:], email)
+func prepare_drop_table(stmt *DropTableStmt, statement *Statement, catalog *Table) PrepareResult {
+	statement.stype = STATEMENT_DROP_TABLE
+	return prepare_table(&stmt.table, statement, catalog)
+}
 
+func prepare_rename_table(stmt *RenameTableStmt, statement *Statement, catalog *Table) PrepareResult {
+	statement.stype = STATEMENT_ALTER_TABLE
+	if result := prepare_table(&stmt.table, statement, catalog); result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.schema = statement.table.schema.renamed(stmt.newName.text)
 	return PREPARE_SUCCESS
 }
 
-func prepare_statement(input_buffer *InputBuffer, statement *Statement) PrepareResult {
-	if bytes.HasPrefix(input_buffer.buffer, []byte("insert")) {
-		return prepare_insert(input_buffer, statement)
+func prepare_add_column(stmt *AddColumnStmt, statement *Statement, catalog *Table) PrepareResult {
+	statement.stype = STATEMENT_ALTER_TABLE
+	if result := prepare_table(&stmt.table, statement, catalog); result != PREPARE_SUCCESS {
+		return result
+	}
+	schema := statement.table.schema
+
+	def := stmt.column
+	if schema.columnIndex(def.name) >= 0 {
+		return statement.syntaxError(syntaxErrorf(def.pos, "duplicate column %q", def.name))
+	}
+	if def.primaryKey {
+		return statement.syntaxError(syntaxErrorf(def.pos, "cannot add a primary key column"))
 	}
-	if bytes.Equal(input_buffer.buffer, []byte("select")) {
-		statement.stype = STATEMENT_SELECT
+	column, err := newColumn(def)
+	if err != nil {
+		return statement.syntaxError(err)
+	}
+	statement.schema = schema.withColumn(column)
+	return PREPARE_SUCCESS
+}
+
+func prepare_insert(stmt *InsertStmt, statement *Statement, catalog *Table) PrepareResult {
+	statement.stype = STATEMENT_INSERT
+	if result := prepare_table(stmt.table, statement, catalog); result != PREPARE_SUCCESS {
+		return result
+	}
+	schema := statement.table.schema
+
+	// The index of the column each value is for
+	columns := make([]int, 0, len(schema.columns))
+	for i, column := range stmt.columns {
+		index := schema.columnIndex(column.text)
+		if index < 0 {
+			return statement.prepareError(fmt.Errorf("no such column: %s", column.text))
+		}
+		if slices.Contains(columns, index) {
+			return statement.syntaxError(syntaxErrorf(stmt.columns[i], "column %v is given twice", column))
+		}
+		columns = append(columns, index)
+	}
+	if stmt.columns == nil {
+		for i := range schema.columns {
+			columns = append(columns, i)
+		}
+	}
+	if len(stmt.values) != len(columns) {
+		return statement.prepareError(fmt.Errorf("%d values for %d columns", len(stmt.values), len(columns)))
+	}
+
+	statement.rowToInsert = make([]any, len(schema.columns))
+	for i, column := range schema.columns {
+		statement.rowToInsert[i] = column.defaultValue
+	}
+	for i, expr := range stmt.values {
+		column := columns[i]
+		if result := prepare_value(expr, &schema.columns[column], statement, &statement.rowToInsert[column]); result != PREPARE_SUCCESS {
+			return result
+		}
+	}
+
+	// A row without a key is given the next one when it is inserted
+	if schema.keyColumn >= 0 && statement.rowToInsert[schema.keyColumn] != nil {
+		key := statement.rowToInsert[schema.keyColumn].(int64)
+		if key < 0 {
+			return PREPARE_NEGATIVE_ID
+		}
+		if key > math.MaxUint32 {
+			return statement.prepareError(fmt.Errorf("%s %d is out of range", schema.keyName(), key))
+		}
+	}
+
+	return PREPARE_SUCCESS
+}
+
+// prepare_key_range turns a where clause on the key into a range of keys.
+// It accepts the key compared with =, <, <=, > or >= to an integer and "key
+// between a and b"; no clause matches every row.
+func prepare_key_range(where Expr, statement *Statement, keyRange *keyRange) PrepareResult {
+	*keyRange = fullKeyRange
+	if where == nil {
 		return PREPARE_SUCCESS
 	}
 
-	return PREPARE_UNRECOGNIZED_STATEMENT
+	var column, first, last Expr
+	var op string
+	switch where := where.(type) {
+	case *BinaryExpr:
+		column, first, last, op = where.left, where.right, where.right, where.op
+	case *BetweenExpr:
+		if !where.not {
+			column, first, last, op = where.expr, where.low, where.high, "between"
+		}
+	}
+	keyName := statement.table.schema.keyName()
+	if column == nil {
+		return statement.syntaxError(syntaxErrorf(where.position(), "expected a comparison of %q, got %v", keyName, where.position()))
+	}
+	if ref, ok := column.(*ColumnRef); !ok || !strings.EqualFold(ref.name, keyName) {
+		return statement.syntaxError(syntaxErrorf(column.position(), "expected %q, got %v", keyName, column.position()))
+	}
+
+	ids := make([]int64, 2)
+	for i, expr := range []Expr{first, last} {
+		if result := prepare_id(expr, statement, &ids[i]); result != PREPARE_SUCCESS {
+			return result
+		}
+	}
+
+	low, high := int64(0), int64(math.MaxUint32)
+	switch op {
+	case "between":
+		low, high = ids[0], ids[1]
+	case "=":
+		low, high = ids[0], ids[0]
+	case "<":
+		high = ids[0] - 1
+	case "<=":
+		high = ids[0]
+	case ">":
+		low = ids[0] + 1
+	case ">=":
+		low = ids[0]
+	default:
+		return statement.syntaxError(syntaxErrorf(where.position(), "unsupported operator %v", where.position()))
+	}
+
+	if low > high || low > math.MaxUint32 {
+		// Nothing can match
+		*keyRange = emptyKeyRange
+		return PREPARE_SUCCESS
+	}
+	keyRange.low, keyRange.high = uint32(low), uint32(min(high, math.MaxUint32))
+	return PREPARE_SUCCESS
+}
+
+// prepare_expr compiles an expression over the columns of the table of the
+// statement.
+func prepare_expr(expr Expr, statement *Statement, compiled *evaluator) PrepareResult {
+	var err error
+	*compiled, err = compileExpr(expr, statement.table.schema)
+	if syntaxErr, ok := err.(*SyntaxError); ok {
+		return statement.syntaxError(syntaxErr)
+	}
+	if err != nil {
+		return statement.prepareError(err)
+	}
+	return PREPARE_SUCCESS
+}
+
+// prepare_order_by compiles the terms of an order by clause, which are
+// either the alias of a result column or an expression over the table. A
+// sort that leads with the key in ascending order is left out, since the
+// table is read in that order anyway.
+func prepare_order_by(terms []OrderingTerm, aliases map[string]evaluator, statement *Statement) PrepareResult {
+	for i, term := range terms {
+		ref, isRef := term.expr.(*ColumnRef)
+		if isRef {
+			if compiled, ok := aliases[strings.ToLower(ref.name)]; ok {
+				statement.orderBy = append(statement.orderBy, compiled)
+				statement.orderDescending = append(statement.orderDescending, term.descending)
+				continue
+			}
+		}
+		if i == 0 && isRef && !term.descending && isKeyRef(ref, statement.table.schema) {
+			return PREPARE_SUCCESS
+		}
+
+		var compiled evaluator
+		if result := prepare_expr(term.expr, statement, &compiled); result != PREPARE_SUCCESS {
+			return result
+		}
+		statement.orderBy = append(statement.orderBy, compiled)
+		statement.orderDescending = append(statement.orderDescending, term.descending)
+	}
+	return PREPARE_SUCCESS
+}
+
+func prepare_select(stmt *SelectStmt, statement *Statement, catalog *Table) PrepareResult {
+	statement.stype = STATEMENT_SELECT
+	if result := prepare_table(stmt.table, statement, catalog); result != PREPARE_SUCCESS {
+		return result
+	}
+	schema := statement.table.schema
+
+	// The result columns named by an alias, which order by can refer to
+	aliases := make(map[string]evaluator)
+	for _, column := range stmt.columns {
+		if column.expr == nil {
+			for i := range schema.columns {
+				i := i
+				statement.columns = append(statement.columns, func(key uint32, row []any) any { return row[i] })
+				statement.columnNames = append(statement.columnNames, schema.columns[i].name)
+			}
+			continue
+		}
+		var compiled evaluator
+		if result := prepare_expr(column.expr, statement, &compiled); result != PREPARE_SUCCESS {
+			return result
+		}
+		statement.columns = append(statement.columns, compiled)
+		if column.alias != nil {
+			statement.columnNames = append(statement.columnNames, column.alias.text)
+			aliases[strings.ToLower(column.alias.text)] = compiled
+		} else {
+			statement.columnNames = append(statement.columnNames, column.text)
+		}
+	}
+	if result := prepare_order_by(stmt.orderBy, aliases, statement); result != PREPARE_SUCCESS {
+		return result
+	}
+
+	statement.limit, statement.offset = -1, 0
+	if result := prepare_count(stmt.limit, statement, &statement.limit); result != PREPARE_SUCCESS {
+		return result
+	}
+	if result := prepare_count(stmt.offset, statement, &statement.offset); result != PREPARE_SUCCESS {
+		return result
+	}
+	// A negative limit is no limit, and a negative offset skips nothing
+	statement.offset = max(statement.offset, 0)
+
+	statement.keyRange = planKeyRange(stmt.where, schema)
+	if stmt.where == nil {
+		return PREPARE_SUCCESS
+	}
+	return prepare_expr(stmt.where, statement, &statement.where)
+}
+
+func prepare_delete(stmt *DeleteStmt, statement *Statement, catalog *Table) PrepareResult {
+	statement.stype = STATEMENT_DELETE
+	if result := prepare_table(stmt.table, statement, catalog); result != PREPARE_SUCCESS {
+		return result
+	}
+	return prepare_key_range(stmt.where, statement, &statement.keyRange)
+}
+
+func prepare_update(stmt *UpdateStmt, statement *Statement, catalog *Table) PrepareResult {
+	// update [<table>] set <column> = <value>, ... where <key> = <key>
+	statement.stype = STATEMENT_UPDATE
+	if result := prepare_table(stmt.table, statement, catalog); result != PREPARE_SUCCESS {
+		return result
+	}
+	schema := statement.table.schema
+
+	where, ok := stmt.where.(*BinaryExpr)
+	if !ok || where.op != "=" {
+		token := stmt.pos
+		if stmt.where != nil {
+			token = stmt.where.position()
+		}
+		return statement.syntaxError(syntaxErrorf(token, "update must select its row with where %s = <%s>", schema.keyName(), schema.keyName()))
+	}
+	if result := prepare_key_range(where, statement, &statement.keyRange); result != PREPARE_SUCCESS {
+		return result
+	}
+
+	statement.updates = make(map[int]any)
+	for _, assignment := range stmt.assignments {
+		index := schema.columnIndex(assignment.column.text)
+		if index < 0 {
+			return statement.prepareError(fmt.Errorf("no such column: %s", assignment.column.text))
+		}
+		if index == schema.keyColumn {
+			return statement.syntaxError(syntaxErrorf(assignment.column, "cannot update column %v", assignment.column))
+		}
+		var value any
+		if result := prepare_value(assignment.value, &schema.columns[index], statement, &value); result != PREPARE_SUCCESS {
+			return result
+		}
+		statement.updates[index] = value
+	}
+
+	return PREPARE_SUCCESS
+}
+
+func prepare_statement(input_buffer *InputBuffer, statement *Statement, catalog *Table) PrepareResult {
+	node, err := parse(string(input_buffer.buffer))
+	if errors.Is(err, errUnrecognizedStatement) {
+		return PREPARE_UNRECOGNIZED_STATEMENT
+	}
+	if err != nil {
+		return statement.syntaxError(err)
+	}
+
+	switch node := node.(type) {
+	case nil:
+		return PREPARE_EMPTY_STATEMENT
+	case *CreateTableStmt:
+		return prepare_create_table(node, statement)
+	case *DropTableStmt:
+		return prepare_drop_table(node, statement, catalog)
+	case *RenameTableStmt:
+		return prepare_rename_table(node, statement, catalog)
+	case *AddColumnStmt:
+		return prepare_add_column(node, statement, catalog)
+	case *InsertStmt:
+		return prepare_insert(node, statement, catalog)
+	case *SelectStmt:
+		return prepare_select(node, statement, catalog)
+	case *DeleteStmt:
+		return prepare_delete(node, statement, catalog)
+	case *UpdateStmt:
+		return prepare_update(node, statement, catalog)
+	case *BeginStmt:
+		statement.stype = STATEMENT_BEGIN
+	case *CommitStmt:
+		statement.stype = STATEMENT_COMMIT
+	case *RollbackStmt:
+		statement.stype = STATEMENT_ROLLBACK
+	case *SavepointStmt:
+		statement.stype = STATEMENT_SAVEPOINT
+		statement.savepointName = node.name
+	case *ReleaseStmt:
+		statement.stype = STATEMENT_RELEASE
+		statement.savepointName = node.name
+	case *RollbackToStmt:
+		statement.stype = STATEMENT_ROLLBACK_TO
+		statement.savepointName = node.name
+	default:
+		return PREPARE_UNRECOGNIZED_STATEMENT
+	}
+	return PREPARE_SUCCESS
 }
 
 func (input_buffer *InputBuffer) ReadInput() {
@@ -112,13 +524,51 @@ func doMetaCommand(inputBuffer *InputBuffer, table *Table) MetaCommandResult {
 	if bytes.Equal(inputBuffer.buffer, []byte(".exit")) {
 		table.db_close()
 		return META_COMMAND_EXIT
-	} else if bytes.Equal(inputBuffer.buffer, []byte(".btree")) {
+	} else if args := bytes.Fields(inputBuffer.buffer); string(args[0]) == ".btree" && len(args) <= 2 {
+		// .btree [table], of the default table if none is named
+		name := DEFAULT_TABLE
+		if len(args) == 2 {
+			name = string(args[1])
+		}
+		tree := table.findTable(name)
+		if tree == nil {
+			PrintMsgf("Error: no such table: %s.\n", name)
+			return META_COMMAND_SUCCESS
+		}
 		PrintMsgf("Tree:\n")
-		table.pager.printTree(0, 0)
+		table.pager.printTree(tree.rootPageNum, 0)
+		return META_COMMAND_SUCCESS
+	} else if len(args) == 2 && string(args[0]) == ".headers" && (string(args[1]) == "on" || string(args[1]) == "off") {
+		// .headers on|off, whether select prints the names of its columns
+		table.showHeaders = string(args[1]) == "on"
+		return META_COMMAND_SUCCESS
+	} else if bytes.Equal(inputBuffer.buffer, []byte(".tables")) {
+		for _, name := range table.tableNames() {
+			PrintMsgf("%s\n", name)
+		}
+		return META_COMMAND_SUCCESS
+	} else if bytes.Equal(inputBuffer.buffer, []byte(".freelist")) {
+		table.pager.printFreelist()
+		return META_COMMAND_SUCCESS
+	} else if bytes.Equal(inputBuffer.buffer, []byte(".check")) {
+		problems := table.CheckIntegrity()
+		if len(problems) == 0 {
+			PrintMsgf("ok\n")
+		}
+		for _, problem := range problems {
+			PrintMsgf("%s\n", problem)
+		}
+		return META_COMMAND_SUCCESS
+	} else if bytes.Equal(inputBuffer.buffer, []byte(".checkpoint")) {
+		if table.pager.inTransaction {
+			PrintMsgf("Error: Cannot checkpoint within a transaction.\n")
+			return META_COMMAND_SUCCESS
+		}
+		PrintMsgf("Checkpointed %d pages.\n", table.pager.checkpoint())
 		return META_COMMAND_SUCCESS
 	} else if bytes.Equal(inputBuffer.buffer, []byte(".constants")) {
 		PrintMsgf("Constants:\n")
-		PrintMsgf(printConstants())
+		PrintMsgf(table.pager.printConstants())
 		return META_COMMAND_SUCCESS
 	} else {
 		return META_COMMAND_UNRECOGNIZED_COMMAND
@@ -146,8 +596,13 @@ func Run(table *Table, input_buffer *InputBuffer, opt ...Option) <-chan string {
 				print_prompt()
 				input_buffer.ReadInput()
 			}
-			if input_buffer.buffer[0] == '.' {
-				switch doMetaCommand(input_buffer, table) {
+			if len(input_buffer.buffer) > 0 && input_buffer.buffer[0] == '.' {
+				var result MetaCommandResult
+				if err := catchCorruptPage(func() { result = doMetaCommand(input_buffer, table) }); err != nil {
+					PrintMsgf("Error: %v.\n", err)
+					continue
+				}
+				switch result {
 				case META_COMMAND_SUCCESS:
 					continue
 				case META_COMMAND_UNRECOGNIZED_COMMAND:
@@ -159,7 +614,7 @@ func Run(table *Table, input_buffer *InputBuffer, opt ...Option) <-chan string {
 			}
 
 			statement := &Statement{}
-			switch prepare_statement(input_buffer, statement) {
+			switch prepare_statement(input_buffer, statement, table) {
 			case PREPARE_SUCCESS:
 				// break
 			case PREPARE_NEGATIVE_ID:
@@ -169,25 +624,53 @@ func Run(table *Table, input_buffer *InputBuffer, opt ...Option) <-chan string {
 				PrintMsgf("String is too long.\n")
 				continue
 			case PREPARE_SYNTAX_ERROR:
-				PrintMsgf("Syntax error. Could not parse statement.\n")
+				PrintMsgf("Syntax error at %v.\n", statement.err)
 				continue
 			case PREPARE_UNRECOGNIZED_STATEMENT:
 				PrintMsgf("Unrecognized keyword at start of '%s'.\n",
 					input_buffer.buffer)
 				continue
+			case PREPARE_EMPTY_STATEMENT:
+				continue
+			case PREPARE_ERROR:
+				PrintMsgf("Error: %v.\n", statement.err)
+				continue
 			default:
 				PrintMsgf("err '%v'.\n", statement.stype)
 				os.Exit(1)
 			}
 
+			if statement.stype == STATEMENT_SELECT && table.showHeaders {
+				print_header(statement.columnNames)
+			}
 			switch statement.execute_statement(table) {
 			case EXECUTE_SUCCESS:
-
+				if statement.stype == STATEMENT_DELETE || statement.stype == STATEMENT_UPDATE {
+					PrintMsgf("Rows affected: %d\n", statement.rowsAffected)
+				}
 			case EXECUTE_TABLE_FULL:
 				PrintMsgf("Error: Table full.\n")
 			case EXECUTE_DUPLICATE_KEY:
 				PrintMsgf("Error: Duplicate key.\n")
 				continue
+			case EXECUTE_ROW_NOT_FOUND:
+				PrintMsgf("Error: Row not found.\n")
+				continue
+			case EXECUTE_TRANSACTION_ACTIVE:
+				PrintMsgf("Error: Cannot start a transaction within a transaction.\n")
+				continue
+			case EXECUTE_NO_TRANSACTION:
+				PrintMsgf("Error: No transaction is active.\n")
+				continue
+			case EXECUTE_NO_SUCH_SAVEPOINT:
+				PrintMsgf("Error: No such savepoint: %s.\n", statement.savepointName)
+				continue
+			case EXECUTE_CORRUPT_PAGE, EXECUTE_IO_ERROR:
+				PrintMsgf("Error: %v.\n", statement.err)
+				continue
+			case EXECUTE_TABLE_EXISTS:
+				PrintMsgf("Error: Table %s already exists.\n", statement.schema.name)
+				continue
 			default:
 				PrintMsgf("Error executing statement.\n")
 				os.Exit(1)
@@ -0,0 +1,974 @@
+package laurel
+
+import (
+	"errors"
+	"slices"
+)
+
+var errUnrecognizedStatement = errors.New("unrecognized statement")
+
+/*
+ * Abstract Syntax Tree
+ *
+ * Every node remembers the token it starts at, so that errors found after
+ * parsing can still point at the offending part of the statement.
+ */
+type Node interface {
+	position() Token
+}
+
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// NumberLiteral keeps the text of the number, with a leading '-' when negated.
+type NumberLiteral struct {
+	pos  Token
+	text string
+}
+
+// StringLiteral is a quoted string, or a bare word where a value is expected,
+// keywords included.
+type StringLiteral struct {
+	pos   Token
+	value string
+}
+
+type BlobLiteral struct {
+	pos   Token
+	value []byte
+}
+
+type NullLiteral struct {
+	pos Token
+}
+
+type ColumnRef struct {
+	pos  Token
+	name string
+}
+
+// BinaryExpr compares left and right with one of =, !=, <>, <, <=, > and >=,
+// combines them with and or or, or is arithmetic with +, -, *, / and %, or
+// the concatenation left || right.
+type BinaryExpr struct {
+	pos   Token // the operator
+	op    string
+	left  Expr
+	right Expr
+}
+
+// UnaryExpr is "not expr", "-expr" or "+expr".
+type UnaryExpr struct {
+	pos  Token // the operator
+	op   string
+	expr Expr
+}
+
+// FunctionCall is "name(arg, ...)".
+type FunctionCall struct {
+	pos  Token // the name
+	name string
+	args []Expr
+}
+
+// BetweenExpr is "expr [not] between low and high", both ends included.
+type BetweenExpr struct {
+	pos  Token // the between keyword
+	expr Expr
+	low  Expr
+	high Expr
+	not  bool
+}
+
+// InExpr is "expr [not] in (value, ...)".
+type InExpr struct {
+	pos    Token // the in keyword
+	expr   Expr
+	values []Expr
+	not    bool
+}
+
+// IsNullExpr is "expr is [not] null".
+type IsNullExpr struct {
+	pos  Token // the is keyword
+	expr Expr
+	not  bool
+}
+
+func (e *NumberLiteral) position() Token { return e.pos }
+func (e *StringLiteral) position() Token { return e.pos }
+func (e *BlobLiteral) position() Token   { return e.pos }
+func (e *NullLiteral) position() Token   { return e.pos }
+func (e *ColumnRef) position() Token     { return e.pos }
+func (e *BinaryExpr) position() Token    { return e.pos }
+func (e *UnaryExpr) position() Token     { return e.pos }
+func (e *FunctionCall) position() Token  { return e.pos }
+func (e *BetweenExpr) position() Token   { return e.pos }
+func (e *InExpr) position() Token        { return e.pos }
+func (e *IsNullExpr) position() Token    { return e.pos }
+
+func (*NumberLiteral) exprNode() {}
+func (*StringLiteral) exprNode() {}
+func (*BlobLiteral) exprNode()   {}
+func (*NullLiteral) exprNode()   {}
+func (*ColumnRef) exprNode()     {}
+func (*BinaryExpr) exprNode()    {}
+func (*UnaryExpr) exprNode()     {}
+func (*FunctionCall) exprNode()  {}
+func (*BetweenExpr) exprNode()   {}
+func (*InExpr) exprNode()        {}
+func (*IsNullExpr) exprNode()    {}
+
+// ColumnDef is "<name> <type> [(<size>)] [primary key] [default <value>]"
+// in create table and alter table.
+type ColumnDef struct {
+	pos          Token
+	name         string
+	typeName     Token
+	size         Expr // nil without a size
+	primaryKey   bool
+	defaultValue Expr // nil without a default
+}
+
+// CreateTableStmt is "create table <name> (<column>, ...)". sql is the text
+// of the statement, which is what the database stores as the schema.
+type CreateTableStmt struct {
+	pos     Token
+	name    string
+	columns []ColumnDef
+	sql     string // the text of the statement, from create to the closing parenthesis
+}
+
+type DropTableStmt struct {
+	pos   Token
+	table Token
+}
+
+// RenameTableStmt is "alter table <table> rename to <name>".
+type RenameTableStmt struct {
+	pos     Token
+	table   Token
+	newName Token
+}
+
+// AddColumnStmt is "alter table <table> add [column] <column>".
+type AddColumnStmt struct {
+	pos    Token
+	table  Token
+	column ColumnDef
+}
+
+// InsertStmt is "insert into <table> [(<column>, ...)] values (<value>, ...)",
+// or "insert <value> ..." with a value for every column of the table.
+type InsertStmt struct {
+	pos     Token
+	table   *Token // nil in the short form
+	columns []Token
+	values  []Expr
+}
+
+// ResultColumn is "*", or an expression whose value select returns under
+// alias, or under text if it has none.
+type ResultColumn struct {
+	expr  Expr // nil for *
+	alias *Token
+	text  string // the expression as written
+}
+
+// OrderingTerm is "<expr> [asc | desc]" in an order by clause.
+type OrderingTerm struct {
+	expr       Expr
+	descending bool
+}
+
+// SelectStmt is "select <column>, ... [from <table>] [where <expr>] [order
+// by <term>, ...] [limit <count> [offset <skip>]]", where a column is "*" or
+// "<expr> [[as] <alias>]". The columns can be left out too, for "*". where,
+// limit and offset are nil without their clause. "limit <skip>, <count>" is
+// the same as "limit <count> offset <skip>".
+type SelectStmt struct {
+	pos     Token
+	columns []ResultColumn
+	table   *Token // nil without a from clause
+	where   Expr
+	orderBy []OrderingTerm
+	limit   Expr
+	offset  Expr
+}
+
+// DeleteStmt is "delete [from <table>] [where <expr>]", where is nil without
+// a where clause.
+type DeleteStmt struct {
+	pos   Token
+	table *Token // nil without a from clause
+	where Expr
+}
+
+type Assignment struct {
+	column Token
+	value  Expr
+}
+
+// UpdateStmt is "update [<table>] set <column> = <value>, ... [where <expr>]".
+type UpdateStmt struct {
+	pos         Token
+	table       *Token // nil if the table is left out
+	assignments []Assignment
+	where       Expr
+}
+
+type BeginStmt struct {
+	pos Token
+}
+
+type CommitStmt struct {
+	pos Token
+}
+
+type RollbackStmt struct {
+	pos Token
+}
+
+type SavepointStmt struct {
+	pos  Token
+	name string
+}
+
+// ReleaseStmt is "release [savepoint] <name>".
+type ReleaseStmt struct {
+	pos  Token
+	name string
+}
+
+// RollbackToStmt is "rollback to [savepoint] <name>".
+type RollbackToStmt struct {
+	pos  Token
+	name string
+}
+
+func (s *CreateTableStmt) position() Token { return s.pos }
+func (s *DropTableStmt) position() Token   { return s.pos }
+func (s *RenameTableStmt) position() Token { return s.pos }
+func (s *AddColumnStmt) position() Token   { return s.pos }
+func (s *InsertStmt) position() Token      { return s.pos }
+func (s *SelectStmt) position() Token      { return s.pos }
+func (s *DeleteStmt) position() Token      { return s.pos }
+func (s *UpdateStmt) position() Token      { return s.pos }
+func (s *BeginStmt) position() Token       { return s.pos }
+func (s *CommitStmt) position() Token      { return s.pos }
+func (s *RollbackStmt) position() Token    { return s.pos }
+func (s *SavepointStmt) position() Token   { return s.pos }
+func (s *ReleaseStmt) position() Token     { return s.pos }
+func (s *RollbackToStmt) position() Token  { return s.pos }
+
+var comparisonOperators = []string{"=", "!=", "<>", "<", "<=", ">", ">="}
+
+// parser is a recursive-descent parser over the tokens of one statement.
+type parser struct {
+	input  string
+	tokens []Token
+	pos    int
+}
+
+// parse parses a single statement, optionally ended by a semicolon. It
+// returns a nil Node for an empty statement, errUnrecognizedStatement if
+// the input does not start with a statement keyword, and a *SyntaxError
+// for anything else it cannot parse.
+func parse(input string) (Node, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, tokens: tokens}
+	if p.acceptOperator(";") || p.peek().ttype == TOKEN_EOF {
+		return nil, p.expectEnd()
+	}
+
+	var node Node
+	start := p.next()
+	if start.ttype != TOKEN_KEYWORD {
+		return nil, errUnrecognizedStatement
+	}
+	switch start.text {
+	case "create":
+		node, err = p.parseCreateTable(start)
+	case "drop":
+		node, err = p.parseDropTable(start)
+	case "alter":
+		node, err = p.parseAlterTable(start)
+	case "insert":
+		node, err = p.parseInsert(start)
+	case "select":
+		node, err = p.parseSelect(start)
+	case "delete":
+		node, err = p.parseDelete(start)
+	case "update":
+		node, err = p.parseUpdate(start)
+	case "begin":
+		node = &BeginStmt{pos: start}
+	case "commit":
+		node = &CommitStmt{pos: start}
+	case "rollback":
+		node, err = p.parseRollback(start)
+	case "savepoint":
+		var name string
+		name, err = p.expectIdentifier()
+		node = &SavepointStmt{pos: start, name: name}
+	case "release":
+		p.acceptKeyword("savepoint")
+		var name string
+		name, err = p.expectIdentifier()
+		node = &ReleaseStmt{pos: start, name: name}
+	default:
+		return nil, errUnrecognizedStatement
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.acceptOperator(";")
+	return node, p.expectEnd()
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+// next consumes a token, returning TOKEN_EOF forever at the end.
+func (p *parser) next() Token {
+	token := p.tokens[p.pos]
+	if token.ttype != TOKEN_EOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if token := p.peek(); token.ttype == TOKEN_KEYWORD && token.text == keyword {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptOperator(operator string) bool {
+	if token := p.peek(); token.ttype == TOKEN_OPERATOR && token.text == operator {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return syntaxErrorf(p.peek(), "expected %q, got %v", keyword, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) expectOperator(operator string) error {
+	if !p.acceptOperator(operator) {
+		return syntaxErrorf(p.peek(), "expected %q, got %v", operator, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) expectIdentifier() (string, error) {
+	token, err := p.expectName()
+	return token.text, err
+}
+
+// expectName is expectIdentifier for callers that need its position.
+func (p *parser) expectName() (Token, error) {
+	token := p.peek()
+	if token.ttype != TOKEN_IDENTIFIER {
+		return token, syntaxErrorf(token, "expected a name, got %v", token)
+	}
+	p.pos++
+	return token, nil
+}
+
+// textFrom returns the input from start up to the last token consumed.
+func (p *parser) textFrom(start Token) string {
+	return p.input[start.offset:p.tokens[max(p.pos-1, 0)].end]
+}
+
+func (p *parser) expectEnd() error {
+	if token := p.peek(); token.ttype != TOKEN_EOF {
+		return syntaxErrorf(token, "unexpected %v after the end of the statement", token)
+	}
+	return nil
+}
+
+func (p *parser) parseCreateTable(start Token) (Node, error) {
+	stmt := &CreateTableStmt{pos: start}
+	if err := p.expectKeyword("table"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.name, err = p.expectIdentifier(); err != nil {
+		return nil, err
+	}
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+	for {
+		column, err := p.parseColumnDef()
+		if err != nil {
+			return nil, err
+		}
+		stmt.columns = append(stmt.columns, column)
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+	if err := p.expectOperator(")"); err != nil {
+		return nil, err
+	}
+	stmt.sql = p.textFrom(start)
+	return stmt, nil
+}
+
+func (p *parser) parseColumnDef() (ColumnDef, error) {
+	column := ColumnDef{pos: p.peek()}
+	var err error
+	if column.name, err = p.expectIdentifier(); err != nil {
+		return column, err
+	}
+	if column.typeName, err = p.expectName(); err != nil {
+		return column, err
+	}
+	if p.acceptOperator("(") {
+		if column.size, err = p.parseLiteral(); err != nil {
+			return column, err
+		}
+		if err := p.expectOperator(")"); err != nil {
+			return column, err
+		}
+	}
+	if p.acceptKeyword("primary") {
+		if err := p.expectKeyword("key"); err != nil {
+			return column, err
+		}
+		column.primaryKey = true
+	}
+	if p.acceptKeyword("default") {
+		if column.defaultValue, err = p.parseLiteral(); err != nil {
+			return column, err
+		}
+	}
+	return column, nil
+}
+
+func (p *parser) parseDropTable(start Token) (Node, error) {
+	if err := p.expectKeyword("table"); err != nil {
+		return nil, err
+	}
+	table, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	return &DropTableStmt{pos: start, table: table}, nil
+}
+
+// parseAlterTable parses both "alter table <table> rename to <name>" and
+// "alter table <table> add [column] <column>".
+func (p *parser) parseAlterTable(start Token) (Node, error) {
+	if err := p.expectKeyword("table"); err != nil {
+		return nil, err
+	}
+	table, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.acceptKeyword("rename"):
+		if err := p.expectKeyword("to"); err != nil {
+			return nil, err
+		}
+		newName, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		return &RenameTableStmt{pos: start, table: table, newName: newName}, nil
+	case p.acceptKeyword("add"):
+		p.acceptKeyword("column")
+		column, err := p.parseColumnDef()
+		if err != nil {
+			return nil, err
+		}
+		return &AddColumnStmt{pos: start, table: table, column: column}, nil
+	default:
+		return nil, syntaxErrorf(p.peek(), "expected \"rename\" or \"add\", got %v", p.peek())
+	}
+}
+
+func (p *parser) parseInsert(start Token) (Node, error) {
+	stmt := &InsertStmt{pos: start}
+	if !p.acceptKeyword("into") {
+		for p.peek().ttype != TOKEN_EOF && !(p.peek().ttype == TOKEN_OPERATOR && p.peek().text == ";") {
+			value, err := p.parseWord()
+			if err != nil {
+				return nil, err
+			}
+			stmt.values = append(stmt.values, value)
+		}
+		if len(stmt.values) == 0 {
+			return nil, syntaxErrorf(p.peek(), "expected a value, got %v", p.peek())
+		}
+		return stmt, nil
+	}
+
+	table, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.table = &table
+	if p.acceptOperator("(") {
+		for {
+			column, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			stmt.columns = append(stmt.columns, column)
+			if !p.acceptOperator(",") {
+				break
+			}
+		}
+		if err := p.expectOperator(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("values"); err != nil {
+		return nil, err
+	}
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		stmt.values = append(stmt.values, value)
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+	return stmt, p.expectOperator(")")
+}
+
+func (p *parser) parseSelect(start Token) (Node, error) {
+	stmt := &SelectStmt{pos: start}
+	if token := p.peek(); token.ttype == TOKEN_EOF || (token.ttype == TOKEN_OPERATOR && token.text == ";") ||
+		(token.ttype == TOKEN_KEYWORD && slices.Contains([]string{"where", "order", "limit"}, token.text)) {
+		stmt.columns = []ResultColumn{{}}
+	}
+	for stmt.columns == nil || p.acceptOperator(",") {
+		column, err := p.parseResultColumn()
+		if err != nil {
+			return nil, err
+		}
+		stmt.columns = append(stmt.columns, column)
+	}
+
+	if p.acceptKeyword("from") {
+		table, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.table = &table
+	}
+	var err error
+	if stmt.where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+	if stmt.orderBy, err = p.parseOrderBy(); err != nil {
+		return nil, err
+	}
+	if !p.acceptKeyword("limit") {
+		return stmt, nil
+	}
+	if stmt.limit, err = p.parseLiteral(); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeyword("offset"):
+		stmt.offset, err = p.parseLiteral()
+	case p.acceptOperator(","):
+		stmt.offset = stmt.limit
+		stmt.limit, err = p.parseLiteral()
+	}
+	return stmt, err
+}
+
+// parseOrderBy parses an optional order by clause, returning nil if there
+// is none.
+func (p *parser) parseOrderBy() ([]OrderingTerm, error) {
+	if !p.acceptKeyword("order") {
+		return nil, nil
+	}
+	if err := p.expectKeyword("by"); err != nil {
+		return nil, err
+	}
+	var terms []OrderingTerm
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		term := OrderingTerm{expr: expr}
+		if !p.acceptKeyword("asc") {
+			term.descending = p.acceptKeyword("desc")
+		}
+		terms = append(terms, term)
+		if !p.acceptOperator(",") {
+			return terms, nil
+		}
+	}
+}
+
+func (p *parser) parseResultColumn() (ResultColumn, error) {
+	if p.acceptOperator("*") {
+		return ResultColumn{}, nil
+	}
+	start := p.peek()
+	expr, err := p.parseExpr()
+	if err != nil {
+		return ResultColumn{}, err
+	}
+	column := ResultColumn{expr: expr, text: p.textFrom(start)}
+	if p.acceptKeyword("as") || p.peek().ttype == TOKEN_IDENTIFIER {
+		alias, err := p.expectName()
+		if err != nil {
+			return column, err
+		}
+		column.alias = &alias
+	}
+	return column, nil
+}
+
+func (p *parser) parseDelete(start Token) (Node, error) {
+	stmt := &DeleteStmt{pos: start}
+	if p.acceptKeyword("from") {
+		table, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.table = &table
+	}
+	var err error
+	stmt.where, err = p.parseWhere()
+	return stmt, err
+}
+
+func (p *parser) parseUpdate(start Token) (Node, error) {
+	stmt := &UpdateStmt{pos: start}
+	if p.peek().ttype == TOKEN_IDENTIFIER {
+		table := p.next()
+		stmt.table = &table
+	}
+	if err := p.expectKeyword("set"); err != nil {
+		return nil, err
+	}
+	for {
+		column := p.peek()
+		if _, err := p.expectIdentifier(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOperator("="); err != nil {
+			return nil, err
+		}
+		value, err := p.parseWord()
+		if err != nil {
+			return nil, err
+		}
+		stmt.assignments = append(stmt.assignments, Assignment{column: column, value: value})
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+
+	var err error
+	stmt.where, err = p.parseWhere()
+	return stmt, err
+}
+
+// parseRollback parses both "rollback" and "rollback to [savepoint] <name>".
+func (p *parser) parseRollback(start Token) (Node, error) {
+	if !p.acceptKeyword("to") {
+		return &RollbackStmt{pos: start}, nil
+	}
+	p.acceptKeyword("savepoint")
+	name, err := p.expectIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	return &RollbackToStmt{pos: start, name: name}, nil
+}
+
+// parseWhere parses an optional where clause, returning nil if there is none.
+func (p *parser) parseWhere() (Expr, error) {
+	if !p.acceptKeyword("where") {
+		return nil, nil
+	}
+	return p.parseExpr()
+}
+
+// parseExpr parses an expression. From the loosest binding, its operators
+// are or, and, not, the comparisons, none of which can be chained, + and -,
+// *, / and %, ||, and last the unary - and +.
+func (p *parser) parseExpr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for token := p.peek(); p.acceptKeyword("or"); token = p.peek() {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{pos: token, op: token.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for token := p.peek(); p.acceptKeyword("and"); token = p.peek() {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{pos: token, op: token.text, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if token := p.peek(); p.acceptKeyword("not") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{pos: token, op: token.text, expr: expr}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	token := p.peek()
+	if p.acceptKeyword("is") {
+		not := p.acceptKeyword("not")
+		if err := p.expectKeyword("null"); err != nil {
+			return nil, err
+		}
+		return &IsNullExpr{pos: token, expr: left, not: not}, nil
+	}
+
+	// not may only be followed by between or in here
+	not := p.acceptKeyword("not")
+	token = p.peek()
+	switch {
+	case p.acceptKeyword("between"):
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpr{pos: token, expr: left, low: low, high: high, not: not}, nil
+	case p.acceptKeyword("in"):
+		if err := p.expectOperator("("); err != nil {
+			return nil, err
+		}
+		in := &InExpr{pos: token, expr: left, not: not}
+		for {
+			value, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			in.values = append(in.values, value)
+			if !p.acceptOperator(",") {
+				break
+			}
+		}
+		return in, p.expectOperator(")")
+	case not:
+		return nil, syntaxErrorf(token, "expected \"between\" or \"in\", got %v", token)
+	}
+
+	if token.ttype != TOKEN_OPERATOR || !slices.Contains(comparisonOperators, token.text) {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryExpr{pos: token, op: token.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	return p.parseBinary([]string{"*", "/", "%"}, p.parseConcat)
+}
+
+func (p *parser) parseConcat() (Expr, error) {
+	return p.parseBinary([]string{"||"}, p.parseUnary)
+}
+
+// parseBinary parses operands joined by any of operators, which associate
+// to the left.
+func (p *parser) parseBinary(operators []string, parseOperand func() (Expr, error)) (Expr, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek()
+		if token.ttype != TOKEN_OPERATOR || !slices.Contains(operators, token.text) {
+			return left, nil
+		}
+		p.pos++
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{pos: token, op: token.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	token := p.peek()
+	if token.ttype != TOKEN_OPERATOR || (token.text != "-" && token.text != "+") {
+		return p.parseOperand()
+	}
+	if token.text == "-" && p.tokens[p.pos+1].ttype == TOKEN_NUMBER {
+		// A negative number, which parseLiteral reads as one literal
+		return p.parseOperand()
+	}
+	p.pos++
+	expr, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &UnaryExpr{pos: token, op: token.text, expr: expr}, nil
+}
+
+// parseOperand parses a column name, a function call, a literal or an
+// expression in parentheses.
+func (p *parser) parseOperand() (Expr, error) {
+	if token := p.peek(); token.ttype == TOKEN_IDENTIFIER {
+		p.pos++
+		if !p.acceptOperator("(") {
+			return &ColumnRef{pos: token, name: token.text}, nil
+		}
+		call := &FunctionCall{pos: token, name: token.text}
+		if p.acceptOperator(")") {
+			return call, nil
+		}
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if !p.acceptOperator(",") {
+				break
+			}
+		}
+		return call, p.expectOperator(")")
+	}
+	if p.acceptOperator("(") {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expectOperator(")")
+	}
+	return p.parseLiteral()
+}
+
+// parseValue parses a literal, reading a bare word as a string even if it
+// is a keyword other than null.
+func (p *parser) parseValue() (Expr, error) {
+	switch token := p.peek(); {
+	case token.ttype == TOKEN_IDENTIFIER:
+		p.pos++
+		return &StringLiteral{pos: token, value: token.text}, nil
+	case token.ttype == TOKEN_KEYWORD && token.text != "null":
+		p.pos++
+		return &StringLiteral{pos: token, value: p.input[token.offset:token.end]}, nil
+	}
+	return p.parseLiteral()
+}
+
+// parseWord parses a value of the short form of insert or of an update
+// assignment, in which a bare word runs on through the tokens that touch it,
+// such as the "@" and "." of an unquoted email address.
+func (p *parser) parseWord() (Expr, error) {
+	end := p.pos + 1
+	for isWordToken(p.tokens[end-1], p.input) && isWordToken(p.tokens[end], p.input) &&
+		p.tokens[end].offset == p.tokens[end-1].end {
+		end++
+	}
+	first, last := p.tokens[p.pos], p.tokens[end-1]
+	negativeNumber := end-p.pos == 2 && first.text == "-" && last.ttype == TOKEN_NUMBER
+	if end-p.pos == 1 || negativeNumber {
+		return p.parseValue()
+	}
+	p.pos = end
+	return &StringLiteral{pos: first, value: p.input[first.offset:last.end]}, nil
+}
+
+// isWordToken reports whether a token can be part of a bare word: anything
+// but a quoted string, blob or identifier, a comma, or the end of the
+// statement.
+func isWordToken(token Token, input string) bool {
+	switch token.ttype {
+	case TOKEN_KEYWORD, TOKEN_NUMBER:
+		return true
+	case TOKEN_IDENTIFIER:
+		return input[token.offset] != '"'
+	case TOKEN_OPERATOR:
+		return token.text != "," && token.text != ";"
+	}
+	return false
+}
+
+func (p *parser) parseLiteral() (Expr, error) {
+	token := p.next()
+	switch {
+	case token.ttype == TOKEN_NUMBER:
+		return &NumberLiteral{pos: token, text: token.text}, nil
+	case token.ttype == TOKEN_STRING:
+		return &StringLiteral{pos: token, value: token.text}, nil
+	case token.ttype == TOKEN_BLOB:
+		return &BlobLiteral{pos: token, value: []byte(token.text)}, nil
+	case token.ttype == TOKEN_KEYWORD && token.text == "null":
+		return &NullLiteral{pos: token}, nil
+	case token.ttype == TOKEN_OPERATOR && token.text == "-" && p.peek().ttype == TOKEN_NUMBER:
+		return &NumberLiteral{pos: token, text: "-" + p.next().text}, nil
+	default:
+		return nil, syntaxErrorf(token, "expected a value, got %v", token)
+	}
+}
@@ -0,0 +1,160 @@
+package laurel
+
+import "math"
+
+// fullKeyRange matches every key.
+var fullKeyRange = keyRange{low: 0, high: math.MaxUint32}
+
+// emptyKeyRange matches no key.
+var emptyKeyRange = keyRange{low: 1, high: 0}
+
+func (r keyRange) isEmpty() bool {
+	return r.low > r.high
+}
+
+func (r keyRange) intersect(other keyRange) keyRange {
+	return keyRange{low: max(r.low, other.low), high: min(r.high, other.high)}
+}
+
+// hull returns the smallest range holding both ranges.
+func (r keyRange) hull(other keyRange) keyRange {
+	if r.isEmpty() {
+		return other
+	}
+	if other.isEmpty() {
+		return r
+	}
+	return keyRange{low: min(r.low, other.low), high: max(r.high, other.high)}
+}
+
+// planKeyRange narrows down the keys of the rows a where clause can match,
+// from the comparisons of the key with numbers that the clause requires.
+// Rows within the range still have to be tested against the clause, which
+// may reject them for its other conditions, but rows outside of it need not
+// be read at all.
+func planKeyRange(where Expr, schema *Schema) keyRange {
+	switch where := where.(type) {
+	case *BinaryExpr:
+		switch where.op {
+		case "and":
+			return planKeyRange(where.left, schema).intersect(planKeyRange(where.right, schema))
+		case "or":
+			return planKeyRange(where.left, schema).hull(planKeyRange(where.right, schema))
+		}
+		if isKeyRef(where.left, schema) {
+			return keyComparisonRange(where.op, where.right)
+		}
+		if isKeyRef(where.right, schema) {
+			return keyComparisonRange(reversedOperators[where.op], where.left)
+		}
+	case *BetweenExpr:
+		if !where.not && isKeyRef(where.expr, schema) {
+			return keyComparisonRange(">=", where.low).intersect(keyComparisonRange("<=", where.high))
+		}
+	case *InExpr:
+		if !where.not && isKeyRef(where.expr, schema) {
+			r := emptyKeyRange
+			for _, value := range where.values {
+				r = r.hull(keyComparisonRange("=", value))
+			}
+			return r
+		}
+	}
+	return fullKeyRange
+}
+
+// reversedOperators gives the operator of a comparison once its operands
+// are swapped.
+var reversedOperators = map[string]string{
+	"=": "=", "!=": "!=", "<>": "<>", "<": ">", "<=": ">=", ">": "<", ">=": "<=",
+}
+
+// isKeyRef reports whether an expression is the key of the table, either
+// by the name of its integer primary key or as rowid.
+func isKeyRef(expr Expr, schema *Schema) bool {
+	ref, ok := expr.(*ColumnRef)
+	if !ok {
+		return false
+	}
+	index, _, err := resolveColumn(ref, schema)
+	return err == nil && (index < 0 || index == schema.keyColumn)
+}
+
+// keyComparisonRange returns the keys k for which "k op expr" can be true.
+// Only numbers narrow the range: other values are left for the row by row
+// test to decide.
+func keyComparisonRange(op string, expr Expr) keyRange {
+	value, err := literalValue(expr)
+	if err != nil {
+		return fullKeyRange
+	}
+	var number float64
+	switch value := applyAffinity(value, COLUMN_INTEGER).(type) {
+	case int64:
+		number = float64(value)
+		// Exact bounds for the integers a float64 cannot tell apart
+		if value >= 0 && value <= math.MaxUint32 {
+			return keyIntegerRange(op, value)
+		}
+	case float64:
+		number = value
+	case nil:
+		// Comparisons with NULL are never true
+		return emptyKeyRange
+	default:
+		return fullKeyRange
+	}
+
+	switch op {
+	case "=":
+		if number != math.Trunc(number) {
+			return emptyKeyRange
+		}
+		return boundedKeyRange(number, number)
+	case "<":
+		return boundedKeyRange(math.Inf(-1), math.Ceil(number)-1)
+	case "<=":
+		return boundedKeyRange(math.Inf(-1), math.Floor(number))
+	case ">":
+		return boundedKeyRange(math.Floor(number)+1, math.Inf(1))
+	case ">=":
+		return boundedKeyRange(math.Ceil(number), math.Inf(1))
+	default:
+		return fullKeyRange
+	}
+}
+
+// keyIntegerRange is keyComparisonRange for a value within the range of keys.
+func keyIntegerRange(op string, value int64) keyRange {
+	key := uint32(value)
+	switch op {
+	case "=":
+		return keyRange{low: key, high: key}
+	case "<":
+		if key == 0 {
+			return emptyKeyRange
+		}
+		return keyRange{low: 0, high: key - 1}
+	case "<=":
+		return keyRange{low: 0, high: key}
+	case ">":
+		if key == math.MaxUint32 {
+			return emptyKeyRange
+		}
+		return keyRange{low: key + 1, high: math.MaxUint32}
+	case ">=":
+		return keyRange{low: key, high: math.MaxUint32}
+	default:
+		return fullKeyRange
+	}
+}
+
+// boundedKeyRange returns the keys between two whole numbers, which may lie
+// outside the range of keys.
+func boundedKeyRange(low, high float64) keyRange {
+	low, high = max(low, 0), min(high, math.MaxUint32)
+	if low > high {
+		return emptyKeyRange
+	}
+	return keyRange{low: uint32(low), high: uint32(high)}
+}
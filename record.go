@@ -0,0 +1,188 @@
+package laurel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Record Format
+ *
+ * A row is stored as a record: a header of varints, starting with the size
+ * of the header itself and followed by the serial type of every column, then
+ * the values of the columns back to back. Integers are stored big-endian in
+ * the fewest bytes that hold them, reals as IEEE 754 doubles, text and blobs
+ * as their bytes, their length being given by the serial type.
+ *
+ * Values are nil, int64, float64, string or []byte.
+ */
+
+const (
+	SERIAL_TYPE_NULL    = 0
+	SERIAL_TYPE_INT8    = 1
+	SERIAL_TYPE_INT16   = 2
+	SERIAL_TYPE_INT24   = 3
+	SERIAL_TYPE_INT32   = 4
+	SERIAL_TYPE_INT48   = 5
+	SERIAL_TYPE_INT64   = 6
+	SERIAL_TYPE_FLOAT64 = 7
+	SERIAL_TYPE_ZERO    = 8
+	SERIAL_TYPE_ONE     = 9
+	SERIAL_TYPE_BLOB    = 12 // and up, even: a blob of (n-12)/2 bytes
+	SERIAL_TYPE_TEXT    = 13 // and up, odd: text of (n-13)/2 bytes
+)
+
+// integerSizes is the size in bytes of the integer serial types.
+var integerSizes = [...]int{SERIAL_TYPE_INT8: 1, SERIAL_TYPE_INT16: 2, SERIAL_TYPE_INT24: 3,
+	SERIAL_TYPE_INT32: 4, SERIAL_TYPE_INT48: 6, SERIAL_TYPE_INT64: 8}
+
+func serialType(value any) uint64 {
+	switch value := value.(type) {
+	case int64:
+		switch {
+		case value == 0:
+			return SERIAL_TYPE_ZERO
+		case value == 1:
+			return SERIAL_TYPE_ONE
+		}
+		for serialType := SERIAL_TYPE_INT8; serialType < SERIAL_TYPE_INT64; serialType++ {
+			bits := 8 * integerSizes[serialType]
+			if value >= -1<<(bits-1) && value < 1<<(bits-1) {
+				return uint64(serialType)
+			}
+		}
+		return SERIAL_TYPE_INT64
+	case float64:
+		return SERIAL_TYPE_FLOAT64
+	case string:
+		return SERIAL_TYPE_TEXT + 2*uint64(len(value))
+	case []byte:
+		return SERIAL_TYPE_BLOB + 2*uint64(len(value))
+	default:
+		return SERIAL_TYPE_NULL
+	}
+}
+
+// serialTypeSize is the number of bytes a value of serialType takes.
+func serialTypeSize(serialType uint64) uint64 {
+	switch {
+	case serialType >= SERIAL_TYPE_BLOB:
+		return (serialType - SERIAL_TYPE_BLOB) / 2
+	case serialType == SERIAL_TYPE_FLOAT64:
+		return 8
+	case serialType >= SERIAL_TYPE_INT8 && serialType <= SERIAL_TYPE_INT64:
+		return uint64(integerSizes[serialType])
+	default:
+		return 0
+	}
+}
+
+func encodeRecord(values []any) []byte {
+	types := make([]byte, 0, len(values))
+	for _, value := range values {
+		types = binary.AppendUvarint(types, serialType(value))
+	}
+	// The size of the header includes the varint holding it
+	headerSize := uint64(len(types)) + 1
+	for uint64(len(binary.AppendUvarint(nil, headerSize))) != headerSize-uint64(len(types)) {
+		headerSize++
+	}
+
+	record := binary.AppendUvarint(nil, headerSize)
+	record = append(record, types...)
+	for _, value := range values {
+		switch value := value.(type) {
+		case int64:
+			size := serialTypeSize(serialType(value))
+			for i := int(size) - 1; i >= 0; i-- {
+				record = append(record, byte(value>>(8*i)))
+			}
+		case float64:
+			record = binary.BigEndian.AppendUint64(record, math.Float64bits(value))
+		case string:
+			record = append(record, value...)
+		case []byte:
+			record = append(record, value...)
+		}
+	}
+	return record
+}
+
+// decodeRecord returns the values of a record, which may be followed by
+// unused bytes, or false if it is malformed.
+func decodeRecord(record []byte) ([]any, bool) {
+	headerSize, n := binary.Uvarint(record)
+	if n <= 0 || headerSize > uint64(len(record)) {
+		return nil, false
+	}
+	header, body := record[n:headerSize], record[headerSize:]
+
+	values := make([]any, 0)
+	for len(header) > 0 {
+		serialType, n := binary.Uvarint(header)
+		if n <= 0 || serialType == 10 || serialType == 11 || serialTypeSize(serialType) > uint64(len(body)) {
+			return nil, false
+		}
+		header = header[n:]
+		size := serialTypeSize(serialType)
+		data := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType == SERIAL_TYPE_NULL:
+			values = append(values, nil)
+		case serialType == SERIAL_TYPE_ZERO || serialType == SERIAL_TYPE_ONE:
+			values = append(values, int64(serialType-SERIAL_TYPE_ZERO))
+		case serialType == SERIAL_TYPE_FLOAT64:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(data)))
+		case serialType >= SERIAL_TYPE_TEXT && serialType%2 == 1:
+			values = append(values, string(data))
+		case serialType >= SERIAL_TYPE_BLOB:
+			values = append(values, append([]byte{}, data...))
+		default:
+			// Sign-extend from the first byte
+			value := int64(int8(data[0]))
+			for _, b := range data[1:] {
+				value = value<<8 | int64(b)
+			}
+			values = append(values, value)
+		}
+	}
+	return values, true
+}
+
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		s := strconv.FormatFloat(value, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s
+	case string:
+		return value
+	case []byte:
+		return fmt.Sprintf("x'%x'", value)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func print_header(names []string) {
+	PrintMsgf("(%s)\n", strings.Join(names, ", "))
+}
+
+func print_row(row []any) {
+	fields := make([]string, len(row))
+	for i, value := range row {
+		fields[i] = formatValue(value)
+	}
+	PrintMsgf("(%s)\n", strings.Join(fields, ", "))
+}
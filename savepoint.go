@@ -0,0 +1,101 @@
+package laurel
+
+import "slices"
+
+// savepoint remembers how to get back to a point inside a transaction: the
+// image of every page as it was before its first modification after the
+// savepoint was opened and before any later savepoint was.
+type savepoint struct {
+	name       string
+	numPages   uint32
+	pageImages map[uint32][]byte
+	// set when the savepoint opened the transaction, whose commit then
+	// happens when it is released
+	startedTransaction bool
+}
+
+func (pager *Pager) openSavepoint(name string, startedTransaction bool) {
+	pager.savepoints = append(pager.savepoints, &savepoint{
+		name:               name,
+		numPages:           pager.numPages,
+		pageImages:         make(map[uint32][]byte),
+		startedTransaction: startedTransaction,
+	})
+}
+
+// savepointPage saves the image of a page that is about to be modified in
+// the innermost savepoint. Older savepoints either hold the page already or
+// saw it unchanged.
+func (pager *Pager) savepointPage(page *cachedPage) {
+	if len(pager.savepoints) == 0 {
+		return
+	}
+	sp := pager.savepoints[len(pager.savepoints)-1]
+	if _, ok := sp.pageImages[page.pageNum]; ok || page.pageNum >= sp.numPages {
+		return
+	}
+	sp.pageImages[page.pageNum] = slices.Clone(page.data)
+}
+
+// findSavepoint returns the index of the innermost savepoint called name,
+// or -1 if there is none.
+func (pager *Pager) findSavepoint(name string) int {
+	for i := len(pager.savepoints) - 1; i >= 0; i-- {
+		if pager.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// releaseSavepoint forgets the savepoint at index and every savepoint opened
+// after it, keeping their changes, and reports whether that ends the
+// transaction.
+func (pager *Pager) releaseSavepoint(index int) bool {
+	if index > 0 {
+		outer := pager.savepoints[index-1]
+		for _, sp := range pager.savepoints[index:] {
+			for pageNum, image := range sp.pageImages {
+				if _, ok := outer.pageImages[pageNum]; !ok && pageNum < outer.numPages {
+					outer.pageImages[pageNum] = image
+				}
+			}
+		}
+	}
+	startedTransaction := pager.savepoints[index].startedTransaction
+	pager.savepoints = pager.savepoints[:index]
+	return startedTransaction
+}
+
+// rollbackToSavepoint undoes every change made since the savepoint at index
+// was opened. The savepoint itself stays open, savepoints opened after it
+// are gone.
+func (pager *Pager) rollbackToSavepoint(index int) {
+	target := pager.savepoints[index]
+
+	// Newest images first, so that the oldest image of a page wins
+	for i := len(pager.savepoints) - 1; i >= index; i-- {
+		for pageNum, image := range pager.savepoints[i].pageImages {
+			if pageNum >= target.numPages {
+				continue
+			}
+			page := pager.cachedPage(pageNum)
+			pager.markDirty(page)
+			copy(page.data, image)
+		}
+	}
+
+	// Pages allocated since the savepoint no longer exist
+	for element := pager.lru.Front(); element != nil; {
+		next := element.Next()
+		if page := element.Value.(*cachedPage); page.pageNum >= target.numPages {
+			pager.lru.Remove(element)
+			delete(pager.cache, page.pageNum)
+		}
+		element = next
+	}
+	pager.numPages = target.numPages
+
+	pager.savepoints = pager.savepoints[:index+1]
+	target.pageImages = make(map[uint32][]byte)
+}
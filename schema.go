@@ -0,0 +1,327 @@
+package laurel
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type ColumnType int
+
+const (
+	COLUMN_INTEGER ColumnType = iota
+	COLUMN_TEXT
+	COLUMN_REAL
+	COLUMN_BLOB
+)
+
+var columnTypeNames = map[string]ColumnType{
+	"integer": COLUMN_INTEGER,
+	"int":     COLUMN_INTEGER,
+	"text":    COLUMN_TEXT,
+	"real":    COLUMN_REAL,
+	"blob":    COLUMN_BLOB,
+}
+
+func (ctype ColumnType) String() string {
+	return [...]string{"INTEGER", "TEXT", "REAL", "BLOB"}[ctype]
+}
+
+// ROWID is the name of the key of a table without an integer primary key.
+const ROWID = "rowid"
+
+var ErrCorruptSchema = errors.New("malformed database schema")
+
+type Column struct {
+	name       string
+	ctype      ColumnType
+	maxLength  int // of text and blob values, 0 if unlimited
+	primaryKey bool
+	// defaultValue is given to the column by inserts that leave it out,
+	// and read from rows stored before the column was added.
+	defaultValue any
+}
+
+// Schema describes the columns of a table. Rows are keyed by the value of
+// the integer primary key column, which is not stored in the record, or by
+// a hidden rowid if there is none.
+type Schema struct {
+	name      string
+	columns   []Column
+	keyColumn int // -1 without an integer primary key
+	sql       string
+}
+
+func newSchema(stmt *CreateTableStmt) (*Schema, error) {
+	schema := &Schema{name: stmt.name, keyColumn: -1, sql: stmt.sql}
+	for i, def := range stmt.columns {
+		if schema.columnIndex(def.name) >= 0 {
+			return nil, syntaxErrorf(def.pos, "duplicate column %q", def.name)
+		}
+		column, err := newColumn(def)
+		if err != nil {
+			return nil, err
+		}
+		if def.primaryKey {
+			if schema.keyColumn >= 0 {
+				return nil, syntaxErrorf(def.pos, "table %q has more than one primary key", stmt.name)
+			}
+			schema.keyColumn = i
+		}
+		schema.columns = append(schema.columns, column)
+	}
+	return schema, nil
+}
+
+// newColumn makes the column of a definition, apart from the checks that
+// need the other columns of the table.
+func newColumn(def ColumnDef) (Column, error) {
+	column := Column{name: def.name, primaryKey: def.primaryKey}
+	ctype, ok := columnTypeNames[strings.ToLower(def.typeName.text)]
+	if !ok {
+		return column, syntaxErrorf(def.typeName, "unknown column type %v", def.typeName)
+	}
+	column.ctype = ctype
+
+	if def.size != nil {
+		size, err := integerValue(def.size)
+		if err != nil {
+			return column, err
+		}
+		if size <= 0 || size > math.MaxInt32 || (ctype != COLUMN_TEXT && ctype != COLUMN_BLOB) {
+			return column, syntaxErrorf(def.size.position(), "invalid size for column %q", def.name)
+		}
+		column.maxLength = int(size)
+	}
+	if def.primaryKey && ctype != COLUMN_INTEGER {
+		return column, syntaxErrorf(def.pos, "only an INTEGER column can be the primary key")
+	}
+	if def.defaultValue != nil {
+		value, err := literalValue(def.defaultValue)
+		if err != nil {
+			return column, err
+		}
+		if column.defaultValue, err = convertValue(value, &column); err != nil {
+			return column, syntaxErrorf(def.defaultValue.position(), "%v", err)
+		}
+		if column.maxLength > 0 && valueLength(column.defaultValue) > column.maxLength {
+			return column, syntaxErrorf(def.defaultValue.position(), "default of column %q is too long", def.name)
+		}
+	}
+	return column, nil
+}
+
+// parseSchema parses a schema as stored in the database.
+func parseSchema(sql string) (*Schema, error) {
+	node, err := parse(sql)
+	stmt, ok := node.(*CreateTableStmt)
+	if err != nil || !ok {
+		return nil, ErrCorruptSchema
+	}
+	schema, err := newSchema(stmt)
+	if err != nil {
+		return nil, ErrCorruptSchema
+	}
+	return schema, nil
+}
+
+// columnIndex returns the index of the named column, or -1 if there is none.
+func (schema *Schema) columnIndex(name string) int {
+	for i, column := range schema.columns {
+		if strings.EqualFold(column.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// keyName is the name by which statements refer to the key of the table.
+func (schema *Schema) keyName() string {
+	if schema.keyColumn < 0 {
+		return ROWID
+	}
+	return schema.columns[schema.keyColumn].name
+}
+
+// withColumn returns the schema of the table once column is added to it.
+func (schema *Schema) withColumn(column Column) *Schema {
+	altered := &Schema{name: schema.name, columns: slices.Clone(schema.columns), keyColumn: schema.keyColumn}
+	altered.columns = append(altered.columns, column)
+	altered.sql = altered.createSQL()
+	return altered
+}
+
+// renamed returns the schema of the table once it is renamed to name.
+func (schema *Schema) renamed(name string) *Schema {
+	altered := &Schema{name: name, columns: schema.columns, keyColumn: schema.keyColumn}
+	altered.sql = altered.createSQL()
+	return altered
+}
+
+// createSQL writes the create statement of an altered schema, which is
+// stored in its place.
+func (schema *Schema) createSQL() string {
+	columns := make([]string, len(schema.columns))
+	for i, column := range schema.columns {
+		def := quoteName(column.name) + " " + strings.ToLower(column.ctype.String())
+		if column.maxLength > 0 {
+			def += fmt.Sprintf("(%d)", column.maxLength)
+		}
+		if column.primaryKey {
+			def += " primary key"
+		}
+		if column.defaultValue != nil {
+			def += " default " + sqlLiteral(column.defaultValue)
+		}
+		columns[i] = def
+	}
+	return fmt.Sprintf("create table %s (%s)", quoteName(schema.name), strings.Join(columns, ", "))
+}
+
+// quoteName quotes a name unless it reads as an identifier as it is.
+func quoteName(name string) string {
+	bare := name != "" && isLetter(name[0]) && !keywords[strings.ToLower(name)]
+	for i := 0; bare && i < len(name); i++ {
+		bare = isWordByte(name[i])
+	}
+	if bare {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlLiteral writes a value as a literal that parses back to it.
+func sqlLiteral(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return describeValue(value)
+	}
+}
+
+// literalValue returns the value of a literal.
+func literalValue(expr Expr) (any, error) {
+	switch expr := expr.(type) {
+	case *NullLiteral:
+		return nil, nil
+	case *NumberLiteral:
+		if value, err := strconv.ParseInt(expr.text, 10, 64); err == nil {
+			return value, nil
+		}
+		value, err := strconv.ParseFloat(expr.text, 64)
+		if err != nil {
+			return nil, syntaxErrorf(expr.pos, "malformed number %s", expr.text)
+		}
+		return value, nil
+	case *StringLiteral:
+		return expr.value, nil
+	case *BlobLiteral:
+		return expr.value, nil
+	default:
+		return nil, syntaxErrorf(expr.position(), "expected a value, got %v", expr.position())
+	}
+}
+
+// convertValue converts a value to the type of a column, so that INTEGER
+// columns hold int64, REAL float64, TEXT string and BLOB []byte, or nil.
+func convertValue(value any, column *Column) (any, error) {
+	converted := value
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		switch column.ctype {
+		case COLUMN_REAL:
+			converted = float64(value)
+		case COLUMN_TEXT:
+			converted = strconv.FormatInt(value, 10)
+		}
+	case float64:
+		switch column.ctype {
+		case COLUMN_INTEGER:
+			if value != math.Trunc(value) || value < math.MinInt64 || value >= math.MaxInt64 {
+				converted = value
+			} else {
+				converted = int64(value)
+			}
+		case COLUMN_TEXT:
+			converted = formatValue(value)
+		}
+	case string:
+		if column.ctype == COLUMN_BLOB {
+			converted = []byte(value)
+		}
+	}
+
+	switch converted.(type) {
+	case int64:
+		if column.ctype == COLUMN_INTEGER {
+			return converted, nil
+		}
+	case float64:
+		if column.ctype == COLUMN_REAL {
+			return converted, nil
+		}
+	case string:
+		if column.ctype == COLUMN_TEXT {
+			return converted, nil
+		}
+	case []byte:
+		if column.ctype == COLUMN_BLOB {
+			return converted, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot store %s in %v column %s", describeValue(value), column.ctype, column.name)
+}
+
+func describeValue(value any) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", "''"))
+	}
+	return formatValue(value)
+}
+
+// valueLength is the length that the maxLength of a column limits.
+func valueLength(value any) int {
+	switch value := value.(type) {
+	case string:
+		return len(value)
+	case []byte:
+		return len(value)
+	default:
+		return 0
+	}
+}
+
+// encodeRow returns the record of a row, leaving out its key.
+func (schema *Schema) encodeRow(row []any) []byte {
+	if schema.keyColumn < 0 {
+		return encodeRecord(row)
+	}
+	values := slices.Clone(row)
+	values[schema.keyColumn] = nil
+	return encodeRecord(values)
+}
+
+// decodeRow returns the row stored under key as record, or false if the
+// record is malformed. Columns added after the row was stored read as their
+// default.
+func (schema *Schema) decodeRow(key uint32, record []byte) ([]any, bool) {
+	row, ok := decodeRecord(record)
+	if !ok || len(row) > len(schema.columns) {
+		return nil, false
+	}
+	for len(row) < len(schema.columns) {
+		row = append(row, schema.columns[len(row)].defaultValue)
+	}
+	if schema.keyColumn >= 0 {
+		row[schema.keyColumn] = int64(key)
+	}
+	return row, true
+}
@@ -0,0 +1,302 @@
+package laurel
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+/*
+ * External Merge Sort
+ *
+ * The sorter buffers rows until they take up its memory limit, then sorts
+ * them and writes them to a temporary file as a sorted run. Once all rows
+ * are in, the runs are merged, SORTER_MAX_MERGE_RUNS at a time, into one
+ * sorted stream. A run is a sequence of rows, each a varint length followed
+ * by the row encoded as a record. Temporary files are removed as soon as
+ * they are created, so that nothing is left behind if the process dies.
+ *
+ * The sort is stable: rows that compare equal come out in the order they
+ * went in.
+ */
+const (
+	DEFAULT_SORT_MEMORY   = 8 << 20
+	SORTER_MAX_MERGE_RUNS = 16
+	// sortValueOverhead is what a value is counted as taking up besides its
+	// text or blob bytes.
+	sortValueOverhead = 16
+)
+
+var errCorruptSortRun = errors.New("corrupt sort run")
+
+// sorter sorts rows by their first numKeys values, which are compared by
+// compareSortKeys.
+type sorter struct {
+	numKeys     int
+	descending  []bool // for each key
+	memoryLimit int
+
+	rows       [][]any
+	memoryUsed int
+	runs       []*os.File // oldest first
+}
+
+func newSorter(descending []bool, memoryLimit uint32) *sorter {
+	return &sorter{numKeys: len(descending), descending: descending, memoryLimit: int(memoryLimit)}
+}
+
+// compareSortKeys orders rows by their keys, NULL first unless descending.
+func (s *sorter) compareSortKeys(a, b []any) int {
+	for i := 0; i < s.numKeys; i++ {
+		var comparison int
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil:
+			comparison = -1
+		case b[i] == nil:
+			comparison = 1
+		default:
+			comparison = compareValues(a[i], b[i])
+		}
+		if comparison != 0 {
+			if s.descending[i] {
+				return -comparison
+			}
+			return comparison
+		}
+	}
+	return 0
+}
+
+// add adds a row, its keys first, spilling the rows buffered so far if they
+// exceed the memory limit.
+func (s *sorter) add(row []any) error {
+	s.rows = append(s.rows, row)
+	for _, value := range row {
+		s.memoryUsed += valueLength(value) + sortValueOverhead
+	}
+	if s.memoryUsed < s.memoryLimit {
+		return nil
+	}
+	return s.spill()
+}
+
+// spill writes the buffered rows to a new run.
+func (s *sorter) spill() error {
+	slices.SortStableFunc(s.rows, s.compareSortKeys)
+	run, err := createRun()
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	writer := bufio.NewWriter(run)
+	for _, row := range s.rows {
+		if err := writeSortRow(writer, row); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("writing sort run: %w", err)
+	}
+	s.rows, s.memoryUsed = nil, 0
+	return nil
+}
+
+func createRun() (*os.File, error) {
+	run, err := os.CreateTemp("", "laurel-sort-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating sort run: %w", err)
+	}
+	os.Remove(run.Name())
+	return run, nil
+}
+
+func writeSortRow(writer *bufio.Writer, row []any) error {
+	record := encodeRecord(row)
+	if _, err := writer.Write(binary.AppendUvarint(nil, uint64(len(record)))); err != nil {
+		return fmt.Errorf("writing sort run: %w", err)
+	}
+	if _, err := writer.Write(record); err != nil {
+		return fmt.Errorf("writing sort run: %w", err)
+	}
+	return nil
+}
+
+// sorted returns the rows in order. The sorter must not be added to after.
+func (s *sorter) sorted() (sortedRows, error) {
+	if len(s.runs) == 0 {
+		slices.SortStableFunc(s.rows, s.compareSortKeys)
+		return &memoryRows{rows: s.rows}, nil
+	}
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Merging the oldest runs into one that takes their place keeps equal
+	// rows in order
+	for len(s.runs) > SORTER_MAX_MERGE_RUNS {
+		merged, err := s.mergeRuns(s.runs[:SORTER_MAX_MERGE_RUNS])
+		if err != nil {
+			return nil, err
+		}
+		rest := s.runs[SORTER_MAX_MERGE_RUNS:]
+		s.runs = append([]*os.File{merged}, rest...)
+	}
+	return s.openMerge(s.runs)
+}
+
+// mergeRuns merges runs into a new run, and closes them.
+func (s *sorter) mergeRuns(runs []*os.File) (*os.File, error) {
+	merge, err := s.openMerge(runs)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := createRun()
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(merged)
+	for {
+		row, err := merge.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = writeSortRow(writer, row)
+		}
+		if err != nil {
+			merged.Close()
+			return nil, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		merged.Close()
+		return nil, fmt.Errorf("writing sort run: %w", err)
+	}
+	for _, run := range runs {
+		run.Close()
+	}
+	return merged, nil
+}
+
+func (s *sorter) openMerge(runs []*os.File) (*mergedRows, error) {
+	merge := &mergedRows{sorter: s}
+	for i, run := range runs {
+		if _, err := run.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("reading sort run: %w", err)
+		}
+		reader := &runReader{index: i, reader: bufio.NewReader(run)}
+		if err := reader.advance(); err == io.EOF {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		merge.readers = append(merge.readers, reader)
+	}
+	heap.Init(merge)
+	return merge, nil
+}
+
+// close removes the runs of the sorter.
+func (s *sorter) close() {
+	for _, run := range s.runs {
+		run.Close()
+	}
+	s.runs, s.rows = nil, nil
+}
+
+// sortedRows hands out the rows of a sorter in order, then io.EOF.
+type sortedRows interface {
+	next() ([]any, error)
+}
+
+type memoryRows struct {
+	rows [][]any
+}
+
+func (m *memoryRows) next() ([]any, error) {
+	if len(m.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := m.rows[0]
+	m.rows = m.rows[1:]
+	return row, nil
+}
+
+// runReader reads the rows of a run, current being the next one to hand out.
+type runReader struct {
+	index   int // of the run, which breaks ties
+	reader  *bufio.Reader
+	current []any
+}
+
+func (r *runReader) advance() error {
+	length, err := binary.ReadUvarint(r.reader)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("reading sort run: %w", err)
+	}
+	record := make([]byte, length)
+	if _, err := io.ReadFull(r.reader, record); err != nil {
+		return fmt.Errorf("reading sort run: %w", err)
+	}
+	row, ok := decodeRecord(record)
+	if !ok {
+		return errCorruptSortRun
+	}
+	r.current = row
+	return nil
+}
+
+// mergedRows merges runs through a heap of their readers, ordered by their
+// current rows.
+type mergedRows struct {
+	sorter  *sorter
+	readers []*runReader
+}
+
+func (m *mergedRows) Len() int { return len(m.readers) }
+
+func (m *mergedRows) Less(i, j int) bool {
+	comparison := m.sorter.compareSortKeys(m.readers[i].current, m.readers[j].current)
+	if comparison != 0 {
+		return comparison < 0
+	}
+	return m.readers[i].index < m.readers[j].index
+}
+
+func (m *mergedRows) Swap(i, j int) { m.readers[i], m.readers[j] = m.readers[j], m.readers[i] }
+
+func (m *mergedRows) Push(x any) { m.readers = append(m.readers, x.(*runReader)) }
+
+func (m *mergedRows) Pop() any {
+	last := m.readers[len(m.readers)-1]
+	m.readers = m.readers[:len(m.readers)-1]
+	return last
+}
+
+func (m *mergedRows) next() ([]any, error) {
+	if len(m.readers) == 0 {
+		return nil, io.EOF
+	}
+	reader := m.readers[0]
+	row := reader.current
+	if err := reader.advance(); err == io.EOF {
+		heap.Pop(m)
+	} else if err != nil {
+		return nil, err
+	} else {
+		heap.Fix(m, 0)
+	}
+	return row, nil
+}
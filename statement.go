@@ -2,6 +2,7 @@ package laurel
 
 import (
 	"fmt"
+	"io"
 	"os"
 )
 
@@ -10,19 +11,137 @@ type StatementType int
 const (
 	STATEMENT_INSERT StatementType = iota
 	STATEMENT_SELECT
+	STATEMENT_DELETE
+	STATEMENT_UPDATE
+	STATEMENT_BEGIN
+	STATEMENT_COMMIT
+	STATEMENT_ROLLBACK
+	STATEMENT_SAVEPOINT
+	STATEMENT_RELEASE
+	STATEMENT_ROLLBACK_TO
+	STATEMENT_CREATE_TABLE
+	STATEMENT_DROP_TABLE
+	STATEMENT_ALTER_TABLE
 )
 
+// keyRange is an inclusive range of ids, empty when low > high
+type keyRange struct {
+	low  uint32
+	high uint32
+}
+
 type Statement struct {
 	stype       StatementType
-	rowToInsert Row // only used by insert statement
+	table       *Table    // only used by insert, select, delete, update, drop table and alter table statements
+	rowToInsert []any     // only used by insert statement, a value for every column
+	keyRange    keyRange  // only used by select, delete and update statements
+	where       evaluator // only used by select statement, nil without a where clause
+	// only used by select statement, the values it returns for each row and
+	// their names
+	columns     []evaluator
+	columnNames []string
+	// only used by select statement, the keys to sort its rows by, nil if
+	// they are returned in the order of the table
+	orderBy         []evaluator
+	orderDescending []bool
+	// only used by select statement, how many rows it returns, negative for
+	// all of them, after skipping offset rows
+	limit  int64
+	offset int64
+	// only used by update statement, the new values by column index
+	updates map[int]any
+
+	schema *Schema // only used by create table and alter table statements, the new schema
+
+	rowsAffected uint32 // filled in by delete and update statements
+
+	savepointName string // only used by savepoint, release and rollback to statements
+
+	err error // why the statement failed with PREPARE_SYNTAX_ERROR, PREPARE_ERROR, EXECUTE_CORRUPT_PAGE or EXECUTE_IO_ERROR
 }
 
+// execute_statement runs a statement, committing it right away unless it is
+// part of a transaction opened by begin. table is the catalog.
 func (statement *Statement) execute_statement(table *Table) ExecuteResult {
+	pager := table.pager
+	// Within a transaction a statement that fails is undone on its own,
+	// through a savepoint no other statement can name
+	statementSavepoint := pager.inTransaction && !statement.isTransactionControl()
+	if statementSavepoint {
+		pager.openSavepoint("", false)
+	}
+
+	result := EXECUTE_SUCCESS
+	err := catchCorruptPage(func() {
+		result = statement.execute(table)
+		if !pager.inTransaction {
+			pager.commit()
+		}
+		if table.loadCatalog() != nil {
+			panic(&ErrCorruptPage{PageNum: DB_HEADER_PAGE_NUM})
+		}
+	})
+	if err == nil {
+		if statementSavepoint {
+			pager.releaseSavepoint(len(pager.savepoints) - 1)
+		}
+		return result
+	}
+
+	// The statement may have stopped halfway through a change
+	if !statementSavepoint || catchCorruptPage(func() { pager.rollbackToSavepoint(len(pager.savepoints) - 1) }) != nil {
+		if pager.inTransaction {
+			err = fmt.Errorf("%w, the transaction was rolled back", err)
+		}
+		pager.abortTransaction()
+	} else {
+		pager.releaseSavepoint(len(pager.savepoints) - 1)
+	}
+	// which may have undone a create table
+	catchCorruptPage(func() { table.loadCatalog() })
+	statement.err = err
+	return EXECUTE_CORRUPT_PAGE
+}
+
+// isTransactionControl reports whether a statement begins, ends or moves
+// within a transaction rather than reading or changing any table.
+func (statement *Statement) isTransactionControl() bool {
+	switch statement.stype {
+	case STATEMENT_BEGIN, STATEMENT_COMMIT, STATEMENT_ROLLBACK,
+		STATEMENT_SAVEPOINT, STATEMENT_RELEASE, STATEMENT_ROLLBACK_TO:
+		return true
+	}
+	return false
+}
+
+func (statement *Statement) execute(table *Table) ExecuteResult {
 	switch statement.stype {
 	case STATEMENT_INSERT:
-		return execute_insert(statement, table)
+		return execute_insert(statement, statement.table)
 	case STATEMENT_SELECT:
-		return execute_select(statement, table)
+		return execute_select(statement, statement.table)
+	case STATEMENT_DELETE:
+		return execute_delete(statement, statement.table)
+	case STATEMENT_UPDATE:
+		return execute_update(statement, statement.table)
+	case STATEMENT_BEGIN:
+		return execute_begin(table)
+	case STATEMENT_COMMIT:
+		return execute_commit(table)
+	case STATEMENT_ROLLBACK:
+		return execute_rollback(table)
+	case STATEMENT_SAVEPOINT:
+		return execute_savepoint(statement, table)
+	case STATEMENT_RELEASE:
+		return execute_release(statement, table)
+	case STATEMENT_ROLLBACK_TO:
+		return execute_rollback_to(statement, table)
+	case STATEMENT_CREATE_TABLE:
+		return execute_create_table(statement, table)
+	case STATEMENT_DROP_TABLE:
+		return execute_drop_table(statement, table)
+	case STATEMENT_ALTER_TABLE:
+		return execute_alter_table(statement, table)
 	default:
 		fmt.Printf("Unrecognized keyword at start of '%v'.\n", statement.stype)
 		os.Exit(1)
@@ -31,16 +150,25 @@ func (statement *Statement) execute_statement(table *Table) ExecuteResult {
 }
 
 func execute_insert(statement *Statement, table *Table) ExecuteResult {
-	node := table.pager.getPage(table.rootPageNum)
-	numCells := *leafNodeNumCells(node)
-
-	rowToInsert := &statement.rowToInsert
-	keyToInsert := rowToInsert.id
+	schema := table.schema
+	var keyToInsert uint32
+	if schema.keyColumn >= 0 && statement.rowToInsert[schema.keyColumn] != nil {
+		keyToInsert = uint32(statement.rowToInsert[schema.keyColumn].(int64))
+	} else {
+		var ok bool
+		if keyToInsert, ok = table.nextKey(); !ok {
+			return EXECUTE_TABLE_FULL
+		}
+	}
 	cursor := table.tableFind(keyToInsert)
 	if cursor == nil {
 		// TODO:
 		return EXECUTE_SUCCESS
 	}
+	defer cursor.close()
+
+	node := table.pager.getPage(cursor.pageNum)
+	numCells := *leafNodeNumCells(node)
 	if cursor.cellNum < numCells {
 		keyAtIndex := *leafNodeKey(node, cursor.cellNum)
 		if keyAtIndex == keyToInsert {
@@ -48,20 +176,219 @@ func execute_insert(statement *Statement, table *Table) ExecuteResult {
 		}
 	}
 
-	cursor.leafNodeInsert(rowToInsert.id, rowToInsert)
+	cursor.leafNodeInsert(keyToInsert, schema.encodeRow(statement.rowToInsert))
 
 	return EXECUTE_SUCCESS
 }
 
+// execute_select prints the columns of the rows the where clause keeps,
+// reading only the leaves that hold the range of keys planned for it. With
+// an order by clause the rows go through a sorter first. Once the limit is
+// reached no more rows are read.
 func execute_select(statement *Statement, table *Table) ExecuteResult {
-	cursor := table.tableStart()
+	if statement.limit == 0 {
+		return EXECUTE_SUCCESS
+	}
+	output := &selectOutput{skip: statement.offset, remaining: statement.limit}
+
+	var rowSorter *sorter
+	if statement.orderBy != nil {
+		rowSorter = newSorter(statement.orderDescending, table.pager.sortMemory)
+		defer rowSorter.close()
+	}
+
+	keyRange := statement.keyRange
+	cursor := table.tableSeek(keyRange.low)
+	defer cursor.close()
+
+	for !cursor.endOfTable && cursor.cursorKey() <= keyRange.high {
+		key, row := cursor.cursorKey(), cursor.cursorRow()
+		if statement.where == nil || isTrue(statement.where(key, row)) {
+			if rowSorter == nil {
+				if !output.print(evaluateAll(statement.columns, key, row)) {
+					return EXECUTE_SUCCESS
+				}
+			} else {
+				// The sort keys go first, followed by the columns
+				sortRow := append(evaluateAll(statement.orderBy, key, row), evaluateAll(statement.columns, key, row)...)
+				if err := rowSorter.add(sortRow); err != nil {
+					statement.err = err
+					return EXECUTE_IO_ERROR
+				}
+			}
+		}
+		cursor.cursorAdvance()
+	}
+	if rowSorter == nil {
+		return EXECUTE_SUCCESS
+	}
+
+	rows, err := rowSorter.sorted()
+	for err == nil {
+		var row []any
+		if row, err = rows.next(); err == nil && !output.print(row[len(statement.orderBy):]) {
+			return EXECUTE_SUCCESS
+		}
+	}
+	if err != io.EOF {
+		statement.err = err
+		return EXECUTE_IO_ERROR
+	}
+	return EXECUTE_SUCCESS
+}
+
+// selectOutput prints the rows of a select that its offset and limit let
+// through.
+type selectOutput struct {
+	skip      int64 // rows still to be skipped
+	remaining int64 // rows still to be printed, negative if there is no limit
+}
+
+// print prints a row unless it is skipped, and returns false once the limit
+// is reached.
+func (output *selectOutput) print(row []any) bool {
+	if output.skip > 0 {
+		output.skip--
+		return true
+	}
+	print_row(row)
+	if output.remaining > 0 {
+		output.remaining--
+	}
+	return output.remaining != 0
+}
 
-	var row Row
+func evaluateAll(evaluators []evaluator, key uint32, row []any) []any {
+	values := make([]any, len(evaluators))
+	for i, evaluate := range evaluators {
+		values[i] = evaluate(key, row)
+	}
+	return values
+}
+
+func execute_delete(statement *Statement, table *Table) ExecuteResult {
+	// Collect the keys first, deleting while walking the leaves would
+	// move cells out from under the cursor.
+	keyRange := statement.keyRange
+	cursor := table.tableSeek(keyRange.low)
+
+	keys := make([]uint32, 0)
 	for !cursor.endOfTable {
-		deserializeRow(cursor.cursorValue()[:], &row)
-		print_row(&row)
+		key := *leafNodeKey(table.pager.getPage(cursor.pageNum), cursor.cellNum)
+		if key > keyRange.high {
+			break
+		}
+		keys = append(keys, key)
 		cursor.cursorAdvance()
 	}
+	cursor.close()
+
+	for _, key := range keys {
+		if table.tableDelete(key) {
+			statement.rowsAffected++
+		}
+	}
+
+	return EXECUTE_SUCCESS
+}
+
+func execute_update(statement *Statement, table *Table) ExecuteResult {
+	key := statement.keyRange.low
+	cursor := table.tableFind(key)
+	node := table.pager.getPage(cursor.pageNum)
+	if cursor.cellNum >= *leafNodeNumCells(node) || *leafNodeKey(node, cursor.cellNum) != key {
+		cursor.close()
+		return EXECUTE_ROW_NOT_FOUND
+	}
+	row := cursor.cursorRow()
+	cursor.close()
+
+	for column, value := range statement.updates {
+		row[column] = value
+	}
+	table.tableReplace(key, table.schema.encodeRow(row))
+	statement.rowsAffected = 1
+
+	return EXECUTE_SUCCESS
+}
+
+func execute_begin(table *Table) ExecuteResult {
+	if table.pager.inTransaction {
+		return EXECUTE_TRANSACTION_ACTIVE
+	}
+	table.pager.inTransaction = true
+	return EXECUTE_SUCCESS
+}
+
+func execute_commit(table *Table) ExecuteResult {
+	if !table.pager.inTransaction {
+		return EXECUTE_NO_TRANSACTION
+	}
+	table.pager.inTransaction = false
+	table.pager.savepoints = nil
+	// execute_statement commits now that the transaction is over
+	return EXECUTE_SUCCESS
+}
+
+func execute_rollback(table *Table) ExecuteResult {
+	if !table.pager.inTransaction {
+		return EXECUTE_NO_TRANSACTION
+	}
+	table.pager.abortTransaction()
+	return EXECUTE_SUCCESS
+}
+
+// execute_savepoint opens a savepoint, and a transaction along with it if
+// there is none yet.
+func execute_savepoint(statement *Statement, table *Table) ExecuteResult {
+	pager := table.pager
+	pager.openSavepoint(statement.savepointName, !pager.inTransaction)
+	pager.inTransaction = true
+	return EXECUTE_SUCCESS
+}
+
+func execute_release(statement *Statement, table *Table) ExecuteResult {
+	pager := table.pager
+	index := pager.findSavepoint(statement.savepointName)
+	if index < 0 {
+		return EXECUTE_NO_SUCH_SAVEPOINT
+	}
+	if pager.releaseSavepoint(index) {
+		pager.inTransaction = false
+		pager.savepoints = nil
+	}
+	return EXECUTE_SUCCESS
+}
 
+func execute_rollback_to(statement *Statement, table *Table) ExecuteResult {
+	pager := table.pager
+	index := pager.findSavepoint(statement.savepointName)
+	if index < 0 {
+		return EXECUTE_NO_SUCH_SAVEPOINT
+	}
+	pager.rollbackToSavepoint(index)
+	return EXECUTE_SUCCESS
+}
+
+func execute_create_table(statement *Statement, table *Table) ExecuteResult {
+	if table.findTable(statement.schema.name) != nil {
+		return EXECUTE_TABLE_EXISTS
+	}
+	table.createTable(statement.schema)
+	return EXECUTE_SUCCESS
+}
+
+func execute_drop_table(statement *Statement, table *Table) ExecuteResult {
+	table.dropTable(statement.table)
+	return EXECUTE_SUCCESS
+}
+
+// execute_alter_table renames a table or adds a column to it, either way
+// only its schema changes.
+func execute_alter_table(statement *Statement, table *Table) ExecuteResult {
+	if other := table.findTable(statement.schema.name); other != nil && other != statement.table {
+		return EXECUTE_TABLE_EXISTS
+	}
+	table.alterTable(statement.table, statement.schema)
 	return EXECUTE_SUCCESS
 }
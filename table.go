@@ -2,41 +2,42 @@ package laurel
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"slices"
 )
 
 type Table struct {
 	pager       *Pager
 	rootPageNum uint32
-}
+	// internalNodeMaxCells is what fits in a page unless lowered
+	// for testing via WithInternalNodeMaxCells.
+	internalNodeMaxCells uint32
 
-func (t *Table) row_slot(row_num uint32) []byte {
-	page_num := row_num / ROWS_PER_PAGE
-	page := t.pager.getPage(page_num)
-	row_offset := row_num % ROWS_PER_PAGE
-	byte_offset := row_offset * ROW_SIZE
+	schema     *Schema
+	catalogKey uint32 // of the row of the table in the catalog
 
-	return page[byte_offset : byte_offset+ROW_SIZE]
+	// only set on the catalog, which is what DBopen returns
+	tables       map[string]*Table // by lower case name
+	schemaCookie uint32            // of the header the tables were read with
+	showHeaders  bool              // set by .headers, select then prints the names of its columns
 }
 
 func (t *Table) db_close() {
 	pager := t.pager
-	for i := uint32(0); i < t.pager.numPages; i++ {
-		if !IsZeroPage(pager.pages[i]) {
-			pager.pager_flush(i, PAGE_SIZE)
-		}
-		SetZeroPage(pager.pages[i])
+	if pager.inTransaction {
+		// Closing in the middle of a transaction abandons it
+		pager.abortTransaction()
 	}
+	pager.commit()
+	pager.closeWal()
 
 	err := pager.file_descriptor.Close()
 	if err != nil {
 		fmt.Printf("Error closing db file.\n")
 		os.Exit(1)
 	}
-	for i := uint32(0); i < TABLE_MAX_PAGES; i++ {
-		page := pager.pages[i]
-		SetZeroPage(page)
-	}
+	pager.dropCache()
 }
 
 func (t *Table) tableFind(key uint32) *Cursor {
@@ -72,6 +73,7 @@ func (t *Table) leafNodeFind(pageNum uint32, key uint32) *Cursor {
 	cursor := &Cursor{}
 	cursor.table = t
 	cursor.pageNum = pageNum
+	t.pager.pinPage(pageNum)
 
 	// Binary search
 	minIndex := uint32(0)
@@ -101,26 +103,51 @@ func (t *Table) createNewRoot(rightChildPageNum uint32) {
 	// Re-initialize root page to contain the new root node.
 	// New root node points to two children.
 
-	root := t.pager.getPage(t.rootPageNum)
-	rightChild := t.pager.getPage(rightChildPageNum)
+	root := t.pager.getWritablePage(t.rootPageNum)
+	rightChild := t.pager.getWritablePage(rightChildPageNum)
 	leftChildPageNum := t.pager.getUnusedPageNum()
-	leftChild := t.pager.getPage(leftChildPageNum)
+	leftChild := t.pager.getWritablePage(leftChildPageNum)
 
 	// Left child has data copied from old root
 	copy(leftChild[:], root[:])
 	setNodeRoot(leftChild, false)
+	*nodeParent(leftChild) = t.rootPageNum
+	*nodeParent(rightChild) = t.rootPageNum
+	leftChildMaxKey := t.pager.getNodeMaxKey(leftChild)
 
 	// Root node is a new internal node with one key and two children
 	initializeInternalNode(root)
 	setNodeRoot(root, true)
 	*internalNodeNumKeys(root) = 1
 	*internalNodeChild(root, 0) = leftChildPageNum
-	leftChildMaxKey := getNodeMaxKey(leftChild)
 	*internalNodeKey(root, 0) = leftChildMaxKey
 	*internalNodeRightChild(root) = rightChildPageNum
 
-	*nodeParent(leftChild) = t.rootPageNum
-	*nodeParent(rightChild) = t.rootPageNum
+	// The children of an internal left child now live under a new page
+	if getNodeType(leftChild) == NODE_INTERNAL {
+		for i := uint32(0); i <= *internalNodeNumKeys(leftChild); i++ {
+			child := t.pager.getWritablePage(*internalNodeChild(leftChild, i))
+			*nodeParent(child) = leftChildPageNum
+		}
+	}
+}
+
+// nextKey returns the key after the largest in the table, or false if that
+// is the largest key there can be.
+func (t *Table) nextKey() (uint32, bool) {
+	root := t.pager.getPage(t.rootPageNum)
+	if getNodeType(root) == NODE_LEAF && *leafNodeNumCells(root) == 0 {
+		return 1, true
+	}
+	maxKey := t.pager.getNodeMaxKey(root)
+	return maxKey + 1, maxKey < math.MaxUint32
+}
+
+// tableSeek returns a cursor at the first row whose key is at least key.
+func (t *Table) tableSeek(key uint32) *Cursor {
+	cursor := t.tableFind(key)
+	cursor.endOfTable = cursor.cellNum >= *leafNodeNumCells(t.pager.getPage(cursor.pageNum))
+	return cursor
 }
 
 func (t *Table) tableStart() *Cursor {
@@ -135,46 +162,335 @@ func (t *Table) tableStart() *Cursor {
 func (t *Table) internalNodeInsert(parentPageNum, childPageNum uint32) {
 	parent := t.pager.getPage(parentPageNum)
 	child := t.pager.getPage(childPageNum)
-	childMaxKey := getNodeMaxKey(child)
+	childMaxKey := t.pager.getNodeMaxKey(child)
 	index := internalNodeFindChild(parent, childMaxKey)
 
 	originalNumKeys := *internalNodeNumKeys(parent)
-	*internalNodeNumKeys(parent) = originalNumKeys + 1
-
-	if originalNumKeys >= INTERNAL_NODE_MAX_CELLS {
-		fmt.Println("Need to implement splitting internal node")
-		os.Exit(-1)
+	if originalNumKeys >= t.internalNodeMaxCells {
+		t.internalNodeSplitAndInsert(parentPageNum, childPageNum)
+		return
 	}
+	parent = t.pager.getWritablePage(parentPageNum)
+	*internalNodeNumKeys(parent) = originalNumKeys + 1
+	*nodeParent(t.pager.getWritablePage(childPageNum)) = parentPageNum
 
 	rightChildPageNum := *internalNodeRightChild(parent)
 	rightChild := t.pager.getPage(rightChildPageNum)
 
-	if childMaxKey > getNodeMaxKey(rightChild) {
+	if childMaxKey > t.pager.getNodeMaxKey(rightChild) {
 		*internalNodeChild(parent, originalNumKeys) = rightChildPageNum
-		*internalNodeKey(parent, originalNumKeys) = getNodeMaxKey(rightChild)
+		*internalNodeKey(parent, originalNumKeys) = t.pager.getNodeMaxKey(rightChild)
 		*internalNodeRightChild(parent) = childPageNum
 	} else {
 		for i := originalNumKeys; i > index; i-- {
-			destination := internalNodeCell(parent, i)
-			source := internalNodeCell(parent, i-1)
-			*destination = *source
+			*internalNodeChild(parent, i) = *internalNodeChild(parent, i-1)
+			*internalNodeKey(parent, i) = *internalNodeKey(parent, i-1)
 		}
 		*internalNodeChild(parent, index) = childPageNum
 		*internalNodeKey(parent, index) = childMaxKey
 	}
 }
 
-func DBopen(filename string) (*Table, error) {
-	pager, err := pager_open(filename)
+func (t *Table) internalNodeSplitAndInsert(oldPageNum, childPageNum uint32) {
+	// Collect every child of the full node plus the new one in key order,
+	// keep the lower half in the old node and move the upper half to a new
+	// node. Then insert the new node into the parent, or grow a new root.
+	oldNode := t.pager.getPage(oldPageNum)
+	oldMax := t.pager.getNodeMaxKey(oldNode)
+	splittingRoot := isNodeRoot(oldNode)
+	parentPageNum := *nodeParent(oldNode)
+	childMaxKey := t.pager.getNodeMaxKey(t.pager.getPage(childPageNum))
+
+	children, maxKeys := t.internalNodeEntries(oldPageNum)
+	index := uint32(len(children))
+	for i, maxKey := range maxKeys {
+		if maxKey >= childMaxKey {
+			index = uint32(i)
+			break
+		}
+	}
+	children = slices.Insert(children, int(index), childPageNum)
+	maxKeys = slices.Insert(maxKeys, int(index), childMaxKey)
+
+	newPageNum := t.pager.getUnusedPageNum()
+	initializeInternalNode(t.pager.getWritablePage(newPageNum))
+
+	leftCount := len(children) / 2
+	t.internalNodeSetChildren(oldPageNum, children[:leftCount], maxKeys[:leftCount])
+	t.internalNodeSetChildren(newPageNum, children[leftCount:], maxKeys[leftCount:])
+
+	if splittingRoot {
+		t.createNewRoot(newPageNum)
+		return
+	}
+
+	parent := t.pager.getWritablePage(parentPageNum)
+	updateInternalNodeKey(parent, oldMax, maxKeys[leftCount-1])
+	t.internalNodeInsert(parentPageNum, newPageNum)
+}
+
+// internalNodeEntries returns the children of an internal node in key order,
+// right child last, along with the max key of each child's subtree.
+func (t *Table) internalNodeEntries(pageNum uint32) (children, maxKeys []uint32) {
+	node := t.pager.getPage(pageNum)
+	numKeys := *internalNodeNumKeys(node)
+	for i := uint32(0); i < numKeys; i++ {
+		children = append(children, *internalNodeChild(node, i))
+		maxKeys = append(maxKeys, *internalNodeKey(node, i))
+	}
+	rightChildPageNum := *internalNodeRightChild(node)
+	children = append(children, rightChildPageNum)
+	maxKeys = append(maxKeys, t.pager.getNodeMaxKey(t.pager.getPage(rightChildPageNum)))
+	return children, maxKeys
+}
+
+// internalNodeSetChildren rewrites the cells of an internal node so that it
+// points at children, whose subtrees have the given max keys, and makes the
+// node the parent of each of them.
+func (t *Table) internalNodeSetChildren(pageNum uint32, children, maxKeys []uint32) {
+	node := t.pager.getWritablePage(pageNum)
+	numKeys := uint32(len(children) - 1)
+	*internalNodeNumKeys(node) = numKeys
+	for i := uint32(0); i < numKeys; i++ {
+		*internalNodeChild(node, i) = children[i]
+		*internalNodeKey(node, i) = maxKeys[i]
+	}
+	*internalNodeRightChild(node) = children[numKeys]
+
+	for _, childPageNum := range children {
+		*nodeParent(t.pager.getWritablePage(childPageNum)) = pageNum
+	}
+}
+
+// tableDelete removes the row stored under key and reports whether there was one.
+func (t *Table) tableDelete(key uint32) bool {
+	cursor := t.tableFind(key)
+	defer cursor.close()
+	node := t.pager.getPage(cursor.pageNum)
+	if cursor.cellNum >= *leafNodeNumCells(node) || *leafNodeKey(node, cursor.cellNum) != key {
+		return false
+	}
+	t.pager.freeOverflow(leafNodeCell(node, cursor.cellNum))
+	cursor.leafNodeDelete()
+	return true
+}
+
+// tableReplace stores record as the row under key, which the caller made
+// sure exists. A row that grew too large for its leaf is deleted and
+// inserted again, splitting the leaf.
+func (t *Table) tableReplace(key uint32, record []byte) {
+	cursor := t.tableFind(key)
+	node := t.pager.getPage(cursor.pageNum)
+	oldSize := uint32(len(leafNodeCell(node, cursor.cellNum)))
+	if leafNodeFreeSpace(node)+oldSize < t.pager.leafNodeCellSize(uint64(len(record))) {
+		cursor.close()
+		t.tableDelete(key)
+		cursor = t.tableFind(key)
+		defer cursor.close()
+		cursor.leafNodeInsert(key, record)
+		return
+	}
+	defer cursor.close()
+
+	t.pager.freeOverflow(leafNodeCell(node, cursor.cellNum))
+	cell := t.pager.makeLeafNodeCell(key, record)
+	node = t.pager.getWritablePage(cursor.pageNum)
+	leafNodeRemoveCell(node, cursor.cellNum)
+	leafNodeInsertCell(node, cursor.cellNum, cell)
+}
+
+// updateParentKey refreshes the separator key that refers to the node at
+// pageNum after the node's max key changed. A right child has no key of its
+// own, so the change is carried up to the first ancestor that does.
+func (t *Table) updateParentKey(pageNum uint32) {
+	t.setParentKey(pageNum, t.pager.getNodeMaxKey(t.pager.getPage(pageNum)))
+}
+
+// setParentKey is updateParentKey for a node whose max key is to become
+// maxKey.
+func (t *Table) setParentKey(pageNum uint32, maxKey uint32) {
+	node := t.pager.getPage(pageNum)
+	for !isNodeRoot(node) {
+		parentPageNum := *nodeParent(node)
+		parent := t.pager.getPage(parentPageNum)
+		index := internalNodeChildIndex(parent, pageNum)
+		if index < *internalNodeNumKeys(parent) {
+			*internalNodeKey(t.pager.getWritablePage(parentPageNum), index) = maxKey
+			return
+		}
+		pageNum, node = parentPageNum, parent
+	}
+}
+
+// siblingPair returns the node at pageNum and an adjacent sibling under the
+// same parent, ordered left to right, along with the index of the left one.
+func (t *Table) siblingPair(pageNum uint32) (parentPageNum, leftIndex, leftPageNum, rightPageNum uint32) {
+	parentPageNum = *nodeParent(t.pager.getPage(pageNum))
+	parent := t.pager.getPage(parentPageNum)
+	index := internalNodeChildIndex(parent, pageNum)
+	if index > 0 {
+		leftIndex = index - 1
+	}
+	leftPageNum = *internalNodeChild(parent, leftIndex)
+	rightPageNum = *internalNodeChild(parent, leftIndex+1)
+	return parentPageNum, leftIndex, leftPageNum, rightPageNum
+}
+
+func (t *Table) leafNodeRebalance(pageNum uint32) {
+	// Merge the underfull leaf with a sibling when both fit in one node,
+	// otherwise even out the cells between the two.
+	parentPageNum, leftIndex, leftPageNum, rightPageNum := t.siblingPair(pageNum)
+	left := t.pager.getWritablePage(leftPageNum)
+	right := t.pager.getWritablePage(rightPageNum)
+	cells := append(leafNodeCells(left), leafNodeCells(right)...)
+
+	if t.pager.leafNodeFits(cells) {
+		leafNodeSetCells(left, cells)
+		*leafNodeNextLeaf(left) = *leafNodeNextLeaf(right)
+		// The separator of the right leaf, which the merged one takes over,
+		// is stale if the right leaf was emptied
+		t.setParentKey(rightPageNum, t.pager.getNodeMaxKey(left))
+		t.internalNodeRemoveMergedChild(parentPageNum, leftIndex+1)
+		t.pager.freePage(rightPageNum)
+		return
+	}
+
+	leftCount := t.pager.leafNodeSplitPoint(cells)
+	if leftCount == 0 {
+		return
+	}
+	leafNodeSetCells(left, cells[:leftCount])
+	leafNodeSetCells(right, cells[leftCount:])
+
+	parent := t.pager.getWritablePage(parentPageNum)
+	*internalNodeKey(parent, leftIndex) = t.pager.getNodeMaxKey(left)
+	// An emptied right leaf only has a max key again now
+	t.updateParentKey(rightPageNum)
+}
+
+func (t *Table) internalNodeRebalance(pageNum uint32) {
+	// Same as leafNodeRebalance, but moving children between internal nodes.
+	parentPageNum, leftIndex, leftPageNum, rightPageNum := t.siblingPair(pageNum)
+	leftChildren, leftMaxKeys := t.internalNodeEntries(leftPageNum)
+	rightChildren, rightMaxKeys := t.internalNodeEntries(rightPageNum)
+	children := append(leftChildren, rightChildren...)
+	maxKeys := append(leftMaxKeys, rightMaxKeys...)
+
+	if uint32(len(children)) <= t.internalNodeMaxCells+1 {
+		t.internalNodeSetChildren(leftPageNum, children, maxKeys)
+		t.internalNodeRemoveMergedChild(parentPageNum, leftIndex+1)
+		t.pager.freePage(rightPageNum)
+		return
+	}
+
+	leftCount := len(children) / 2
+	t.internalNodeSetChildren(leftPageNum, children[:leftCount], maxKeys[:leftCount])
+	t.internalNodeSetChildren(rightPageNum, children[leftCount:], maxKeys[leftCount:])
+
+	parent := t.pager.getWritablePage(parentPageNum)
+	*internalNodeKey(parent, leftIndex) = maxKeys[leftCount-1]
+}
+
+// internalNodeRemoveMergedChild drops the child at index from an internal
+// node after its contents were merged into the left sibling, which from now
+// on covers the keys of both. The node is rebalanced in turn if that leaves
+// it underfull.
+func (t *Table) internalNodeRemoveMergedChild(pageNum uint32, index uint32) {
+	children, maxKeys := t.internalNodeEntries(pageNum)
+	children = slices.Delete(children, int(index), int(index)+1)
+	maxKeys = slices.Delete(maxKeys, int(index)-1, int(index))
+	t.internalNodeSetChildren(pageNum, children, maxKeys)
+
+	node := t.pager.getPage(pageNum)
+	if isNodeRoot(node) {
+		if len(children) == 1 {
+			t.collapseRoot()
+		}
+		return
+	}
+	if *internalNodeNumKeys(node) < t.internalNodeMaxCells/2 {
+		t.internalNodeRebalance(pageNum)
+	}
+}
+
+func (t *Table) collapseRoot() {
+	// The root is left with a single child, so the child moves into the
+	// root page and the tree loses a level.
+	root := t.pager.getWritablePage(t.rootPageNum)
+	childPageNum := *internalNodeRightChild(root)
+	child := t.pager.getPage(childPageNum)
+	copy(root[:], child[:])
+	setNodeRoot(root, true)
+	*nodeParent(root) = 0
+
+	if getNodeType(root) == NODE_INTERNAL {
+		for i := uint32(0); i <= *internalNodeNumKeys(root); i++ {
+			*nodeParent(t.pager.getWritablePage(*internalNodeChild(root, i))) = t.rootPageNum
+		}
+	}
+	t.pager.freePage(childPageNum)
+}
+
+func DBopen(filename string, opt ...Option) (*Table, error) {
+	opts := loadOptions(opt...)
+	if opts.CacheSize == 0 {
+		opts.CacheSize = DEFAULT_CACHE_SIZE
+	}
+	if opts.SortMemory == 0 {
+		opts.SortMemory = DEFAULT_SORT_MEMORY
+	}
+	if opts.WalAutoCheckpoint == 0 {
+		opts.WalAutoCheckpoint = DEFAULT_WAL_AUTO_CHECKPOINT
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = DEFAULT_PAGE_SIZE
+	}
+	if !validPageSize(opts.PageSize) {
+		return nil, fmt.Errorf("page size %d: %w", opts.PageSize, ErrPageSize)
+	}
+	pager, err := pager_open(filename, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	t := &Table{pager: pager, rootPageNum: 0}
-	if pager.numPages == 0 {
-		rootPage := t.pager.getPage(0)
+	t := &Table{pager: pager, rootPageNum: CATALOG_ROOT_PAGE_NUM, internalNodeMaxCells: pager.internalNodeMaxCells,
+		schema: catalogSchema}
+	if opts.InternalNodeMaxCells != 0 {
+		t.internalNodeMaxCells = min(max(opts.InternalNodeMaxCells, 2), pager.internalNodeMaxCells)
+	}
+	if opts.LeafNodeMaxCells != 0 {
+		pager.setLeafNodeMaxCells(opts.LeafNodeMaxCells)
+	}
+	if pager.numPages == 0 && pager.file_length == 0 {
+		header := t.pager.getWritablePage(DB_HEADER_PAGE_NUM)
+		initializeHeader(header, pager.pageSize)
+		*headerPageCount(header) = 1
+		pager.numPages = 1
+
+		t.rootPageNum = t.pager.getUnusedPageNum()
+		rootPage := t.pager.getWritablePage(t.rootPageNum)
 		initializeLeafNode(rootPage)
 		setNodeRoot(rootPage, true)
+		t.createTable(defaultSchema)
+		pager.commit()
+		t.loadCatalog()
+	} else {
+		// The checksum of a page is only meaningful in a file of the right format
+		header := make([]byte, pager.pageSize)
+		pager.readPage(DB_HEADER_PAGE_NUM, header)
+		if err := validateHeader(header, pager.pageSize); err != nil {
+			pager.close()
+			return nil, &HeaderError{Filename: filename, Err: err}
+		}
+		var schemaErr error
+		if err := catchCorruptPage(func() { schemaErr = t.loadCatalog() }); err != nil {
+			pager.close()
+			return nil, err
+		}
+		if schemaErr != nil {
+			pager.close()
+			return nil, &HeaderError{Filename: filename, Err: schemaErr}
+		}
 	}
 
 	return t, nil
@@ -0,0 +1,358 @@
+package laurel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"slices"
+)
+
+type JournalMode int
+
+const (
+	JOURNAL_MODE_ROLLBACK JournalMode = iota
+	JOURNAL_MODE_WAL
+)
+
+/*
+ * Write-Ahead Log Layout
+ *
+ * In WAL mode a commit appends the modified pages to <db>-wal as frames
+ * instead of overwriting the database file, the last frame of a transaction
+ * carrying the database size as commit marker. Modified pages that have to
+ * leave the cache before the commit are appended early, and only count once
+ * a commit frame follows them; a rollback lets the next transaction write
+ * over them. Readers look a page up in the WAL index before the database
+ * file. A checkpoint copies the newest frame of every page back into the
+ * database file and starts the WAL over.
+ *
+ * Each checksum covers the previous one, so a frame only counts when every
+ * frame before it is intact, and a salt that changes on every restart of the
+ * WAL keeps frames of an earlier generation out.
+ *
+ * Header: magic | page size | salt | checksum
+ * Frames: page number | database size or 0 | salt | checksum | page
+ */
+const (
+	WAL_SUFFIX                  = "-wal"
+	WAL_MAGIC                   = "laurel wal\x00\x00\x00\x00\x00\x00"
+	WAL_MAGIC_SIZE              = len(WAL_MAGIC)
+	WAL_PAGE_SIZE_OFFSET        = WAL_MAGIC_SIZE
+	WAL_SALT_OFFSET             = WAL_PAGE_SIZE_OFFSET + 4
+	WAL_HEADER_CHECKSUM_OFFSET  = WAL_SALT_OFFSET + 4
+	WAL_HEADER_SIZE             = WAL_HEADER_CHECKSUM_OFFSET + 4
+	WAL_FRAME_PAGE_NUM_OFFSET   = 0
+	WAL_FRAME_DB_SIZE_OFFSET    = WAL_FRAME_PAGE_NUM_OFFSET + 4
+	WAL_FRAME_SALT_OFFSET       = WAL_FRAME_DB_SIZE_OFFSET + 4
+	WAL_FRAME_CHECKSUM_OFFSET   = WAL_FRAME_SALT_OFFSET + 4
+	WAL_FRAME_HEADER_SIZE       = WAL_FRAME_CHECKSUM_OFFSET + 4
+	DEFAULT_WAL_AUTO_CHECKPOINT = 1000
+)
+
+func (pager *Pager) walFrameSize() int {
+	return WAL_FRAME_HEADER_SIZE + int(pager.pageSize)
+}
+
+// validWalHeader reports whether a WAL header is intact, which it is unless
+// the WAL was torn before its first commit.
+func validWalHeader(header []byte) bool {
+	return len(header) >= WAL_HEADER_SIZE &&
+		bytes.Equal(header[:WAL_MAGIC_SIZE], []byte(WAL_MAGIC)) &&
+		validPageSize(binary.LittleEndian.Uint32(header[WAL_PAGE_SIZE_OFFSET:])) &&
+		binary.LittleEndian.Uint32(header[WAL_HEADER_CHECKSUM_OFFSET:]) ==
+			crc32.Checksum(header[:WAL_HEADER_CHECKSUM_OFFSET], castagnoliTable)
+}
+
+func walFrameChecksum(previous uint32, frame []byte) uint32 {
+	checksum := crc32.Update(previous, castagnoliTable, frame[:WAL_FRAME_CHECKSUM_OFFSET])
+	return crc32.Update(checksum, castagnoliTable, frame[WAL_FRAME_HEADER_SIZE:])
+}
+
+// resetWal starts a new generation of the WAL with no frames in it.
+func (pager *Pager) resetWal() {
+	if pager.wal_file == nil {
+		wal, err := os.OpenFile(pager.walPath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Printf("Unable to open WAL: %v\n", err)
+			os.Exit(1)
+		}
+		pager.wal_file = wal
+	}
+	pager.walSalt = rand.Uint32()
+	pager.walIndex = make(map[uint32]int64)
+	pager.walPending = make(map[uint32]int64)
+	pager.walFrames = 0
+
+	header := make([]byte, WAL_HEADER_SIZE)
+	copy(header, WAL_MAGIC)
+	binary.LittleEndian.PutUint32(header[WAL_PAGE_SIZE_OFFSET:], pager.pageSize)
+	binary.LittleEndian.PutUint32(header[WAL_SALT_OFFSET:], pager.walSalt)
+	pager.walChecksum = crc32.Checksum(header[:WAL_HEADER_CHECKSUM_OFFSET], castagnoliTable)
+	binary.LittleEndian.PutUint32(header[WAL_HEADER_CHECKSUM_OFFSET:], pager.walChecksum)
+	pager.walLength = int64(WAL_HEADER_SIZE)
+
+	if pager.crashed {
+		return
+	}
+	if err := pager.wal_file.Truncate(0); err != nil {
+		fmt.Printf("Error truncating WAL: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := pager.wal_file.WriteAt(header, 0); err != nil {
+		fmt.Printf("Error writing WAL: %v\n", err)
+		os.Exit(1)
+	}
+	pager.syncFile(pager.wal_file)
+}
+
+// walAppendFrame appends a page to the WAL, as commit frame when dbSize is
+// not 0, and returns the offset of the page within the WAL.
+func (pager *Pager) walAppendFrame(page *cachedPage, dbSize uint32) int64 {
+	if pager.wal_file == nil {
+		pager.resetWal()
+	}
+	frame := make([]byte, pager.walFrameSize())
+	binary.LittleEndian.PutUint32(frame[WAL_FRAME_PAGE_NUM_OFFSET:], page.pageNum)
+	binary.LittleEndian.PutUint32(frame[WAL_FRAME_DB_SIZE_OFFSET:], dbSize)
+	binary.LittleEndian.PutUint32(frame[WAL_FRAME_SALT_OFFSET:], pager.walSalt)
+	setPageChecksum(page.data)
+	copy(frame[WAL_FRAME_HEADER_SIZE:], page.data)
+	pager.walChecksum = walFrameChecksum(pager.walChecksum, frame)
+	binary.LittleEndian.PutUint32(frame[WAL_FRAME_CHECKSUM_OFFSET:], pager.walChecksum)
+
+	if pager.countDiskWrite() {
+		if _, err := pager.wal_file.WriteAt(frame, pager.walLength); err != nil {
+			fmt.Printf("Error writing WAL: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	offset := pager.walLength + WAL_FRAME_HEADER_SIZE
+	pager.walLength += int64(pager.walFrameSize())
+	return offset
+}
+
+// walSpill appends a modified page to the WAL ahead of the commit so that
+// it can leave the cache, and reports whether it did. Only this transaction
+// reads the frame back until it commits.
+func (pager *Pager) walSpill(page *cachedPage) bool {
+	if pager.crashed {
+		// The frame would never reach the WAL
+		return false
+	}
+	if pager.wal_file == nil {
+		pager.resetWal()
+	}
+	if len(pager.walPending) == 0 {
+		pager.walPendingLength = pager.walLength
+		pager.walPendingChecksum = pager.walChecksum
+	}
+	offset := pager.walAppendFrame(page, 0)
+	if pager.crashed {
+		return false
+	}
+	pager.walPending[page.pageNum] = offset
+	page.dirty = false
+	return true
+}
+
+// walCommit appends every modified page to the WAL and makes the frames
+// visible to readers once the commit frame is durable.
+func (pager *Pager) walCommit() {
+	dirtyPages := make([]*cachedPage, 0)
+	for element := pager.lru.Front(); element != nil; element = element.Next() {
+		if page := element.Value.(*cachedPage); page.dirty {
+			dirtyPages = append(dirtyPages, page)
+		}
+	}
+	if len(dirtyPages) == 0 {
+		// Every modification also dirties the header page, so no frame was
+		// spilled either
+		return
+	}
+	slices.SortFunc(dirtyPages, func(a, b *cachedPage) int { return int(a.pageNum) - int(b.pageNum) })
+
+	frames := make(map[uint32]int64)
+	for i, page := range dirtyPages {
+		dbSize := uint32(0)
+		if i == len(dirtyPages)-1 {
+			dbSize = pager.numPages
+		}
+		frames[page.pageNum] = pager.walAppendFrame(page, dbSize)
+	}
+	pager.syncFile(pager.wal_file)
+	if pager.crashed {
+		// The frames never reached the WAL, nothing can read them back
+		return
+	}
+
+	// Spilled frames come first, later frames of the same page win
+	for pageNum, offset := range pager.walPending {
+		pager.walIndex[pageNum] = offset
+	}
+	for pageNum, offset := range frames {
+		pager.walIndex[pageNum] = offset
+	}
+	for _, page := range dirtyPages {
+		page.dirty = false
+	}
+	pager.walFrames += uint32(len(pager.walPending) + len(dirtyPages))
+	clear(pager.walPending)
+	pager.walNumPages = pager.numPages
+	pager.txnNumPages = pager.numPages
+
+	if pager.walFrames >= pager.walAutoCheckpoint {
+		pager.checkpoint()
+	}
+}
+
+// walRollback discards the modified pages, those still cached and those
+// spilled to the WAL, whose frames the next transaction writes over.
+func (pager *Pager) walRollback() {
+	for element := pager.lru.Front(); element != nil; {
+		next := element.Next()
+		page := element.Value.(*cachedPage)
+		if _, spilled := pager.walPending[page.pageNum]; page.dirty || spilled {
+			pager.lru.Remove(element)
+			delete(pager.cache, page.pageNum)
+		}
+		element = next
+	}
+	if len(pager.walPending) > 0 {
+		pager.walLength = pager.walPendingLength
+		pager.walChecksum = pager.walPendingChecksum
+		clear(pager.walPending)
+	}
+	pager.numPages = pager.txnNumPages
+}
+
+// walReadPage fills data from the newest frame of a page this transaction
+// spilled or else the newest committed one, and reports whether the WAL has
+// either.
+func (pager *Pager) walReadPage(page_num uint32, data []byte) bool {
+	offset, ok := pager.walPending[page_num]
+	if !ok {
+		offset, ok = pager.walIndex[page_num]
+	}
+	if !ok {
+		return false
+	}
+	if _, err := pager.wal_file.ReadAt(data, offset); err != nil {
+		fmt.Printf("Error reading WAL: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// checkpoint copies the pages in the WAL back into the database file and
+// returns how many there were. It does nothing within a transaction, whose
+// spilled frames would be lost when the WAL starts over.
+func (pager *Pager) checkpoint() uint32 {
+	if len(pager.walIndex) == 0 || pager.inTransaction || len(pager.walPending) > 0 {
+		return 0
+	}
+
+	pageNums := make([]uint32, 0, len(pager.walIndex))
+	for pageNum := range pager.walIndex {
+		pageNums = append(pageNums, pageNum)
+	}
+	slices.Sort(pageNums)
+
+	data := make([]byte, pager.pageSize)
+	for _, pageNum := range pageNums {
+		if _, err := pager.wal_file.ReadAt(data[:], pager.walIndex[pageNum]); err != nil {
+			fmt.Printf("Error reading WAL: %v\n", err)
+			os.Exit(1)
+		}
+		if pager.countDiskWrite() {
+			if _, err := pager.file_descriptor.WriteAt(data, int64(pageNum)*int64(pager.pageSize)); err != nil {
+				fmt.Printf("Error writing: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if !pager.crashed {
+		if err := pager.file_descriptor.Truncate(int64(pager.walNumPages) * int64(pager.pageSize)); err != nil {
+			fmt.Printf("Error truncating db file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	pager.syncFile(pager.file_descriptor)
+
+	// Only once the database file is durable may the frames go away
+	pager.resetWal()
+	return uint32(len(pageNums))
+}
+
+// closeWal checkpoints and deletes the WAL when the database is closed.
+func (pager *Pager) closeWal() {
+	if pager.wal_file == nil {
+		return
+	}
+	pager.checkpoint()
+	pager.wal_file.Close()
+	pager.wal_file = nil
+	if !pager.crashed {
+		if err := os.Remove(pager.walPath); err != nil {
+			fmt.Printf("Error deleting WAL: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// recoverWal rebuilds the WAL index from a WAL left behind by an earlier
+// session, keeping every transaction whose commit frame made it to disk.
+func (pager *Pager) recoverWal() error {
+	wal, err := os.ReadFile(pager.walPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pager.wal_file, err = os.OpenFile(pager.walPath, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if !validWalHeader(wal) || binary.LittleEndian.Uint32(wal[WAL_PAGE_SIZE_OFFSET:]) != pager.pageSize {
+		// Torn before the first commit, so there is nothing to keep
+		pager.resetWal()
+		return nil
+	}
+
+	pager.walSalt = binary.LittleEndian.Uint32(wal[WAL_SALT_OFFSET:])
+	pager.walChecksum = binary.LittleEndian.Uint32(wal[WAL_HEADER_CHECKSUM_OFFSET:])
+	pager.walLength = int64(WAL_HEADER_SIZE)
+	pager.walIndex = make(map[uint32]int64)
+	pager.walPending = make(map[uint32]int64)
+
+	checksum := pager.walChecksum
+	pending := make(map[uint32]int64)
+	frameSize := pager.walFrameSize()
+	for offset := WAL_HEADER_SIZE; offset+frameSize <= len(wal); offset += frameSize {
+		frame := wal[offset : offset+frameSize]
+		if binary.LittleEndian.Uint32(frame[WAL_FRAME_SALT_OFFSET:]) != pager.walSalt {
+			break
+		}
+		checksum = walFrameChecksum(checksum, frame)
+		if binary.LittleEndian.Uint32(frame[WAL_FRAME_CHECKSUM_OFFSET:]) != checksum {
+			break
+		}
+
+		pending[binary.LittleEndian.Uint32(frame[WAL_FRAME_PAGE_NUM_OFFSET:])] = int64(offset + WAL_FRAME_HEADER_SIZE)
+		if dbSize := binary.LittleEndian.Uint32(frame[WAL_FRAME_DB_SIZE_OFFSET:]); dbSize != 0 {
+			for pageNum, frameOffset := range pending {
+				pager.walIndex[pageNum] = frameOffset
+			}
+			clear(pending)
+			pager.walFrames = uint32((offset + frameSize - WAL_HEADER_SIZE) / frameSize)
+			pager.walNumPages = dbSize
+			pager.walChecksum = checksum
+			pager.walLength = int64(offset + frameSize)
+		}
+	}
+	return nil
+}